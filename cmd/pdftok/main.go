@@ -0,0 +1,209 @@
+// pdftok dumps the tokens from PDF files, one per line, as
+// position, type and quoted value.
+//
+// With -diff it compares the tokens of exactly two files and reports the
+// first difference, as a line for each file giving the byte position, the
+// token index, and the token there. It exits 0 if the files have the same
+// tokens, 1 if they differ, and 2 on trouble, like diff. A file that fails
+// to lex before any difference is found counts as trouble.
+//
+// With -pretty it prints the object structure instead, with dicts and arrays
+// indented one entry per line and stream bodies summarised by their length.
+//
+// With -dump-streams dir it writes each stream body to its own file in dir,
+// as stream_<index>_off<pos>.bin, with the stream dictionary alongside in a
+// .dict file so that the /Filter is known.
+//
+// Except with -diff, the exit status is 0 if every file lexed cleanly, 1 on
+// an I/O error, which stops pdftok at once, and 2 if any file had a lexing
+// error. Bad usage also exits 2. With -q nothing but the errors is printed,
+// which makes pdftok a quick structural sanity check in scripts.
+//
+// (c) Ben Nagy 2015
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/bnagy/pdflex"
+)
+
+var (
+	filter      = flag.String("filter", "", "only print items of these comma separated types, eg Name,String,HexString")
+	diffMode    = flag.Bool("diff", false, "compare the tokens of two files and report the first difference")
+	ignoreSpace = flag.Bool("ignore-space", false, "with -diff, ignore differences in whitespace")
+	prettyMode  = flag.Bool("pretty", false, "print indented object structure instead of tokens")
+	skipTrivia  = flag.Bool("skip-trivia", false, "don't show whitespace or comments")
+	streamDir   = flag.String("dump-streams", "", "write each stream body to a file in this directory")
+	quiet       = flag.Bool("q", false, "print only errors, and use the exit status to report them")
+)
+
+// exit statuses, except in -diff mode
+const (
+	exitOK  = 0 // every file lexed cleanly
+	exitIO  = 1 // a file couldn't be read or written
+	exitLex = 2 // a file had a lexing error, or bad usage
+)
+
+// lexError is an ItemError, as opposed to an I/O error.
+type lexError struct {
+	fn string
+	it pdflex.Item
+}
+
+func (e lexError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.fn, e.it.Pos, e.it.Val)
+}
+
+// parseFilter turns the -filter argument into a set of item types. An empty
+// filter returns a nil set, which shows everything.
+func parseFilter(s string) (map[pdflex.ItemType]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	types := map[string]pdflex.ItemType{}
+	var names []string
+	for t := pdflex.ItemError; t <= pdflex.ItemNull; t++ {
+		types[t.String()] = t
+		names = append(names, t.String())
+	}
+	show := map[pdflex.ItemType]bool{}
+	for _, name := range strings.Split(s, ",") {
+		t, ok := types[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown item type %q, valid types are: %s", name, strings.Join(names, ","))
+		}
+		show[t] = true
+	}
+	return show, nil
+}
+
+// diff lexes a and b in step and returns a description of the first token
+// that differs, or "" if they have the same tokens. If either file fails to
+// lex before a difference is found, they can't be compared, and the
+// lexError is returned instead. Both lexers are drained before returning, so
+// neither goroutine is left blocked.
+func diff(fnA string, a []byte, fnB string, b []byte) (string, error) {
+	la, lb := pdflex.NewLexerBytes(fnA, a), pdflex.NewLexerBytes(fnB, b)
+	defer la.Drain()
+	defer lb.Drain()
+
+	next := func(l *pdflex.Lexer) pdflex.Item {
+		for {
+			it := l.NextItem()
+			if !(*ignoreSpace && it.Typ == pdflex.ItemSpace) {
+				return it
+			}
+		}
+	}
+	for n := 0; ; n++ {
+		ia, ib := next(la), next(lb)
+		if ia.Typ == pdflex.ItemError {
+			return "", lexError{fnA, ia}
+		}
+		if ib.Typ == pdflex.ItemError {
+			return "", lexError{fnB, ib}
+		}
+		if ia.Typ != ib.Typ || ia.Val != ib.Val {
+			return fmt.Sprintf("%s:%d: token %d: %v %q\n%s:%d: token %d: %v %q\n",
+				fnA, ia.Pos, n, ia.Typ, ia.Val, fnB, ib.Pos, n, ib.Typ, ib.Val), nil
+		}
+		if ia.Typ == pdflex.ItemEOF {
+			return "", nil
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] file [file ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	show, err := parseFilter(*filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(exitLex)
+	}
+
+	if *diffMode {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "-diff needs exactly two files")
+			flag.Usage()
+			os.Exit(2)
+		}
+		var raw [2][]byte
+		for i, fn := range flag.Args() {
+			raw[i], err = ioutil.ReadFile(fn)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+		d, err := diff(flag.Arg(0), raw[0], flag.Arg(1), raw[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if d != "" {
+			fmt.Print(d)
+			os.Exit(1)
+		}
+		return
+	}
+
+	status := exitOK
+	for _, fn := range flag.Args() {
+		raw, err := ioutil.ReadFile(fn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitIO)
+		}
+		if *streamDir != "" {
+			err := dumpStreams(*streamDir, fn, raw)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				if _, ok := err.(lexError); !ok {
+					os.Exit(exitIO)
+				}
+				status = exitLex
+			}
+			continue
+		}
+		var opts []pdflex.Option
+		if *skipTrivia && !*prettyMode {
+			opts = append(opts, pdflex.WithSkipTrivia())
+		}
+		l := pdflex.NewLexerBytes(fn, raw, opts...)
+		if *prettyMode && !*quiet {
+			if err := pretty(os.Stdout, l, !*skipTrivia); err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%s\n", fn, err)
+				status = exitLex
+			}
+			continue
+		}
+		for {
+			it := l.NextItem()
+			if it.Typ == pdflex.ItemEOF {
+				break
+			}
+			// errors are always shown so that corruption isn't hidden by the
+			// filter
+			if it.Typ == pdflex.ItemError {
+				fmt.Fprintln(os.Stderr, lexError{fn, it})
+				status = exitLex
+				break
+			}
+			if !*quiet && (show == nil || show[it.Typ]) {
+				fmt.Printf("%d\t%v\t%q\n", it.Pos, it.Typ, it.Val)
+			}
+		}
+	}
+	os.Exit(status)
+}
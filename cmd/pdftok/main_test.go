@@ -0,0 +1,37 @@
+// (c) Ben Nagy 2015
+
+package main
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		differs bool
+		err     string
+	}{
+		{"same", "<< /A 1 >>", "<< /A 1 >>", false, ""},
+		{"different", "<< /A 1 >>", "<< /A 2 >>", true, ""},
+		{"shorter", "1 2", "1", true, ""},
+		{"both bad", "1 @", "1 @", false, "a:2: illegal character: U+0040 '@'"},
+		{"first bad", "1 @", "1 2", false, "a:2: illegal character: U+0040 '@'"},
+		{"second bad", "1 2", "1 )", false, "b:2: illegal character: U+0029 ')'"},
+		{"differ before error", "1 @", "2 @", true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := diff("a", []byte(test.a), "b", []byte(test.b))
+			if (d != "") != test.differs {
+				t.Errorf("diff = %q, want a difference %v", d, test.differs)
+			}
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != test.err {
+				t.Errorf("error %q, want %q", got, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// (c) Ben Nagy 2015
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bnagy/pdflex"
+)
+
+// maxInline is the longest array of simple values that is printed on one line.
+const maxInline = 60
+
+// maxDepth is the deepest nesting that is printed. box recurses for each
+// level, so without a limit a hostile file could overflow the stack.
+const maxDepth = 1000
+
+// node is a dict or array with its contents, or a single value. Indirect
+// references are merged into one value, so that dict entries pair up.
+// Comments are values too, but they are kept out of the pairing.
+type node struct {
+	open  pdflex.Item // the item, or the opener of a dict or array
+	val   string      // the text of a single value
+	kids  []node
+	isBox bool // open is a dict or array opener
+}
+
+// printer pretty prints the items from a lexer. Dicts and arrays are
+// indented by their nesting depth, with one entry per line. Other items keep
+// the line structure of the file, except that obj, endobj and the like always
+// start or end a line, and stream bodies are summarised instead of dumped.
+// Items are read through a Parser so that indirect references are found by
+// the same rule the Parser uses.
+type printer struct {
+	w        *bufio.Writer
+	p        *pdflex.Parser
+	comments bool     // print comments
+	line     []string // pending top level text
+}
+
+// pretty prints the lexer's items to w, stopping at the first error. The
+// lexer must not skip trivia, since whitespace is part of the syntax of an
+// indirect reference, so comments are dropped here instead unless comments
+// is true.
+func pretty(w io.Writer, l *pdflex.Lexer, comments bool) error {
+	p := &printer{w: bufio.NewWriter(w), p: pdflex.NewParser(l), comments: comments}
+	err := p.run()
+	p.flush()
+	p.w.Flush()
+	return err
+}
+
+func (p *printer) next() pdflex.Item {
+	for {
+		it := p.p.NextItem()
+		if it.Typ != pdflex.ItemComment || p.comments {
+			return it
+		}
+	}
+}
+
+func (p *printer) backup(it pdflex.Item) {
+	p.p.Backup(it)
+}
+
+// nextValue returns the next item that isn't whitespace.
+func (p *printer) nextValue() pdflex.Item {
+	for {
+		it := p.next()
+		if it.Typ != pdflex.ItemSpace {
+			return it
+		}
+	}
+}
+
+// flush prints the pending top level text as one line.
+func (p *printer) flush() {
+	if len(p.line) > 0 {
+		fmt.Fprintln(p.w, strings.Join(p.line, " "))
+		p.line = p.line[:0]
+	}
+}
+
+func (p *printer) run() error {
+	for {
+		it := p.next()
+		switch it.Typ {
+		case pdflex.ItemEOF:
+			return nil
+		case pdflex.ItemError:
+			return fmt.Errorf("%d: %s", it.Pos, it.Val)
+		case pdflex.ItemSpace:
+			if strings.ContainsAny(it.Val, "\r\n") {
+				p.flush()
+			}
+		case pdflex.ItemLeftDict, pdflex.ItemLeftArray:
+			n, err := p.box(it)
+			if err != nil {
+				return err
+			}
+			p.line = append(p.line, format(n))
+			p.flush()
+		case pdflex.ItemStream:
+			body := p.next()
+			if body.Typ != pdflex.ItemStreamBody {
+				p.backup(body)
+				break
+			}
+			if end := p.next(); end.Typ != pdflex.ItemEndStream {
+				p.backup(end)
+			}
+			p.flush()
+			fmt.Fprintf(p.w, "stream (%d bytes)\n", len(body.Val))
+		case pdflex.ItemObj:
+			p.line = append(p.line, it.Val)
+			p.flush()
+		case pdflex.ItemComment, pdflex.ItemEndObj:
+			p.flush()
+			p.line = append(p.line, it.Val)
+			p.flush()
+		case pdflex.ItemTrailer, pdflex.ItemXref, pdflex.ItemStartXref:
+			p.flush()
+			p.line = append(p.line, it.Val)
+		default:
+			p.line = append(p.line, it.Val)
+		}
+	}
+}
+
+// box reads the contents of the dict or array opened by open, up to its
+// closer.
+func (p *printer) box(open pdflex.Item) (node, error) {
+	n := node{open: open, isBox: true}
+	if open.Depth >= maxDepth {
+		return n, fmt.Errorf("%d: nesting exceeds maximum depth %d", open.Pos, maxDepth)
+	}
+	for {
+		it := p.nextValue()
+		switch it.Typ {
+		case pdflex.ItemError:
+			return n, fmt.Errorf("%d: %s", it.Pos, it.Val)
+		case pdflex.ItemEOF:
+			return n, fmt.Errorf("%d: unexpected EOF in %v", it.Pos, open.Typ)
+		case pdflex.ItemRightDict, pdflex.ItemRightArray:
+			return n, nil
+		case pdflex.ItemLeftDict, pdflex.ItemLeftArray:
+			kid, err := p.box(it)
+			if err != nil {
+				return n, err
+			}
+			n.kids = append(n.kids, kid)
+		case pdflex.ItemNumber:
+			n.kids = append(n.kids, node{open: it, val: p.ref(it)})
+		default:
+			n.kids = append(n.kids, node{open: it, val: it.Val})
+		}
+	}
+}
+
+// ref returns "N G R" if num starts an indirect reference, otherwise just
+// the number.
+func (p *printer) ref(num pdflex.Item) string {
+	if r, ok := p.p.Ref(num); ok {
+		return fmt.Sprintf("%d %d R", r.Num, r.Gen)
+	}
+	return num.Val
+}
+
+// format returns n as text. Lines after the first are indented by depth.
+func format(n node) string {
+	if !n.isBox {
+		return n.val
+	}
+	indent := strings.Repeat("  ", n.open.Depth)
+	inner := indent + "  "
+	var b strings.Builder
+
+	if n.open.Typ == pdflex.ItemLeftArray {
+		simple := true
+		var vals []string
+		for _, k := range n.kids {
+			simple = simple && !k.isBox && k.open.Typ != pdflex.ItemComment
+			vals = append(vals, k.val)
+		}
+		if s := "[" + strings.Join(vals, " ") + "]"; simple && len(s) <= maxInline {
+			return s
+		}
+		b.WriteString("[\n")
+		for _, k := range n.kids {
+			fmt.Fprintf(&b, "%s%s\n", inner, format(k))
+		}
+		b.WriteString(indent + "]")
+		return b.String()
+	}
+
+	if len(n.kids) == 0 {
+		return "<< >>"
+	}
+	b.WriteString("<<\n")
+	key := ""
+	for _, k := range n.kids {
+		switch {
+		case k.open.Typ == pdflex.ItemComment:
+			fmt.Fprintf(&b, "%s%s\n", inner, k.val)
+		case key == "":
+			key = format(k)
+		default:
+			fmt.Fprintf(&b, "%s%s %s\n", inner, key, format(k))
+			key = ""
+		}
+	}
+	if key != "" {
+		fmt.Fprintf(&b, "%s%s\n", inner, key)
+	}
+	b.WriteString(indent + ">>")
+	return b.String()
+}
@@ -0,0 +1,43 @@
+// (c) Ben Nagy 2015
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bnagy/pdflex"
+)
+
+func TestPrettyRefs(t *testing.T) {
+	in := "<< /A [1.5 2 R 3 %c\n 0 R] /B 4 0 R /C [1 0 R 2 0 R] >>"
+	want := `<<
+  /A [
+    1.5
+    2
+    R
+    3
+    %c
+    0
+    R
+  ]
+  /B 4 0 R
+  /C [1 0 R 2 0 R]
+>>
+`
+	var b strings.Builder
+	if err := pretty(&b, pdflex.NewLexer("test", in), true); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestPrettyDepth(t *testing.T) {
+	in := strings.Repeat("[", 10*maxDepth)
+	var b strings.Builder
+	if err := pretty(&b, pdflex.NewLexer("test", in), true); err == nil || !strings.Contains(err.Error(), "maximum depth") {
+		t.Errorf("got %v, want a depth error", err)
+	}
+}
@@ -0,0 +1,102 @@
+// (c) Ben Nagy 2015
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bnagy/pdflex"
+)
+
+// dumpStreams writes the body of every stream in raw to its own file in dir,
+// named for the stream's index and offset, and prints each name unless -q
+// is given. The stream dictionary, which has the /Filter needed to decode
+// the body, goes in a sidecar file next to it. Existing files are never
+// overwritten, so dumping several PDFs to one directory gets numbered names
+// instead. It stops at the first lexing error, returned as a lexError,
+// keeping what has been written.
+func dumpStreams(dir, fn string, raw []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	l := pdflex.NewLexerBytes(fn, raw)
+	defer l.Drain()
+
+	var (
+		start pdflex.Pos // start of the current top level dict
+		dict  []byte     // the most recent complete top level dict
+		n     int
+	)
+	for {
+		it := l.NextItem()
+		switch it.Typ {
+		case pdflex.ItemEOF:
+			return nil
+		case pdflex.ItemError:
+			return lexError{fn, it}
+		case pdflex.ItemLeftDict:
+			if it.Depth == 0 {
+				start = it.Pos
+			}
+		case pdflex.ItemRightDict:
+			if it.Depth == 0 {
+				dict = raw[start:it.End()]
+			}
+		case pdflex.ItemStreamBody:
+			base, err := create(dir, fmt.Sprintf("stream_%d_off%d", n, it.Pos), []byte(it.Val), dict)
+			if err != nil {
+				return err
+			}
+			if !*quiet {
+				fmt.Println(base + ".bin")
+			}
+			dict, n = nil, n+1
+		}
+	}
+}
+
+// create writes body to dir/name.bin and dict, if there is one, to
+// dir/name.dict. If either file exists already, a number is added to name
+// until neither does, so a body is never paired with a stale dict. It
+// returns the path it used without the extension.
+func create(dir, name string, body, dict []byte) (string, error) {
+	base := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		err := writeNew(base+".bin", body)
+		if err == nil {
+			if dict != nil {
+				err = writeNew(base+".dict", dict)
+			} else if _, err = os.Lstat(base + ".dict"); err == nil {
+				err = os.ErrExist
+			} else if os.IsNotExist(err) {
+				err = nil
+			}
+			if err != nil {
+				os.Remove(base + ".bin")
+			}
+		}
+		if os.IsExist(err) {
+			base = filepath.Join(dir, fmt.Sprintf("%s_%d", name, i))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return base, nil
+	}
+}
+
+// writeNew writes data to a new file at path. It fails if the file exists.
+func writeNew(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,58 @@
+// (c) Ben Nagy 2015
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDumpStreams(t *testing.T) {
+	*quiet = true
+	defer func() { *quiet = false }()
+
+	in := "1 0 obj\n<< /Length 3 >>\nstream\nabc\nendstream\nendobj\n"
+	base := "stream_0_off" + strconv.Itoa(strings.Index(in, "abc"))
+	dir, err := ioutil.TempDir("", "pdftok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a stale dict from something else takes the first name, and the
+	// second dump gets the next number
+	if err := ioutil.WriteFile(filepath.Join(dir, base+".dict"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := dumpStreams(dir, "test.pdf", []byte(in)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	want := []string{base + ".dict", base + "_1.bin", base + "_1.dict", base + "_2.bin", base + "_2.dict"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	for name, data := range map[string]string{
+		base + ".dict":   "stale",
+		base + "_1.bin":  "abc\n",
+		base + "_1.dict": "<< /Length 3 >>",
+	} {
+		if got, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != data {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, data)
+		}
+	}
+}
@@ -11,9 +11,12 @@ package pdflex
 
 import (
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
+	"unsafe"
 )
 
 type Pos int
@@ -22,10 +25,63 @@ type Pos int
 type Item struct {
 	Typ ItemType // The type of this item.
 	Pos Pos      // The starting position, in bytes, of this item in the input string.
-	Val string   // The value of this item.
+	Val string   // The value of this item, unless the lexer uses WithLazyValues.
+	// Depth is the number of arrays, dicts and procedures enclosing the
+	// item. Openers and closers have the depth of the object that contains
+	// them, so the contents of a top level << >> are at depth 1.
+	Depth int
+	end   Pos // end of the item, needed when Val is not populated
 }
 
-// itemType identifies the type of lex items.
+// End returns the position just past the end of the item in the input, so
+// that the item spans [Pos, End).
+func (i Item) End() Pos {
+	if i.end > i.Pos {
+		return i.end
+	}
+	return i.Pos + Pos(len(i.Val))
+}
+
+// lazy reports whether i came from a lexer using WithLazyValues, so that it
+// has a span but no Val.
+func (i Item) lazy() bool {
+	return i.Val == "" && i.end > i.Pos && i.Typ != ItemError
+}
+
+// IsReal reports whether a number item has a decimal point, as in 3.14, .5
+// or 5. Like Int and Float, it needs Val, so it is false for a lazy item; use
+// Lexer.Value to fill in Val first.
+func (i Item) IsReal() bool {
+	return i.Typ == ItemNumber && strings.IndexByte(i.Val, '.') >= 0
+}
+
+// Int returns the value of an integer number item. ok is false for reals,
+// integers that overflow an int64, lazy items, and items that aren't numbers.
+func (i Item) Int() (n int64, ok bool) {
+	if i.Typ != ItemNumber || i.lazy() || i.IsReal() {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(i.Val, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Float returns the value of a number item, integer or real. ok is false for
+// lazy items and items that aren't numbers.
+func (i Item) Float() (f float64, ok bool) {
+	if i.Typ != ItemNumber || i.lazy() {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(i.Val, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// ItemType identifies the type of lex items.
 type ItemType int
 
 const (
@@ -37,17 +93,22 @@ const (
 	ItemRightDict // >> token
 	ItemLeftArray
 	ItemRightArray
+	ItemLeftBrace  // { opening a PostScript calculator procedure 7.10.5
+	ItemRightBrace // }
 	ItemStreamBody // raw contents of a stream
 	ItemString     // PDF Literal String 7.3.4.2
 	ItemHexString  // PDF Hex String 7.3.4.3
 	ItemComment    // 7.2.3
 	ItemName       // PDF Name Object 7.3.5
 	ItemWord       // catchall for an unrecognised blob of alnums
+	ItemPreamble   // junk before the %PDF- header
+	ItemPostamble  // junk after the final %%EOF marker
 	// Keywords appear after all the rest.
-	ItemKeyword // used only to delimit the keywords
-	ItemObj     // just the obj and endobj markers
+	ItemKeyword  // used only to delimit the keywords
+	ItemOperator // content stream operator, see NewContentLexer
+	ItemObj      // just the obj and endobj markers
 	ItemEndObj
-	ItemStream // just the markers
+	ItemStream // just the markers, stream includes its mandatory EOL
 	ItemEndStream
 	ItemTrailer
 	ItemXref
@@ -57,26 +118,91 @@ const (
 	ItemNull
 )
 
+var itemNames = map[ItemType]string{
+	ItemError:      "Error",
+	ItemEOF:        "EOF",
+	ItemNumber:     "Number",
+	ItemSpace:      "Space",
+	ItemLeftDict:   "LeftDict",
+	ItemRightDict:  "RightDict",
+	ItemLeftArray:  "LeftArray",
+	ItemRightArray: "RightArray",
+	ItemLeftBrace:  "LeftBrace",
+	ItemRightBrace: "RightBrace",
+	ItemStreamBody: "StreamBody",
+	ItemString:     "String",
+	ItemHexString:  "HexString",
+	ItemComment:    "Comment",
+	ItemName:       "Name",
+	ItemWord:       "Word",
+	ItemPreamble:   "Preamble",
+	ItemPostamble:  "Postamble",
+	ItemKeyword:    "Keyword",
+	ItemOperator:   "Operator",
+	ItemObj:        "Obj",
+	ItemEndObj:     "EndObj",
+	ItemStream:     "Stream",
+	ItemEndStream:  "EndStream",
+	ItemTrailer:    "Trailer",
+	ItemXref:       "Xref",
+	ItemStartXref:  "StartXref",
+	ItemTrue:       "True",
+	ItemFalse:      "False",
+	ItemNull:       "Null",
+}
+
+// String returns the name of the item type without the Item prefix, so
+// ItemHexString is "HexString".
+func (t ItemType) String() string {
+	if s, ok := itemNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("ItemType(%d)", int(t))
+}
+
 // If they need to be used directly in code then a constant string is easiest
 const (
 	leftDict    = "<<"
 	rightDict   = ">>"
 	leftStream  = "stream"
 	rightStream = "endstream"
+	pdfHeader   = "%PDF-"
+	eofMarker   = "%%EOF"
 )
 
 // keytoks maps special strings to itemTypes
-var keytoks = map[string]itemType{
-	"obj":       itemObj,
-	"endobj":    itemEndObj,
-	leftStream:  itemStream,
-	rightStream: itemEndStream,
-	"trailer":   itemTrailer,
-	"xref":      itemXref,
-	"startxref": itemStartXref,
-	"true":      itemTrue,
-	"false":     itemFalse,
-	"null":      itemNull,
+var keytoks = map[string]ItemType{
+	"obj":       ItemObj,
+	"endobj":    ItemEndObj,
+	leftStream:  ItemStream,
+	rightStream: ItemEndStream,
+	"trailer":   ItemTrailer,
+	"xref":      ItemXref,
+	"startxref": ItemStartXref,
+	"true":      ItemTrue,
+	"false":     ItemFalse,
+	"null":      ItemNull,
+}
+
+// contentOps are the operators that can appear in a content stream.
+// cf PDF3200_2008.pdf Annex A.2
+const contentOps = `b B b* B* BDC BI BMC BT BX c cm CS cs d d0 d1 Do DP EI EMC ET
+EX f F f* G g gs h i ID j J K k l m M MP n q Q re RG rg ri s S SC sc SCN scn sh
+T* Tc Td TD Tf Tj TJ TL Tm Tr Ts Tw Tz v w W W* y ' "`
+
+// contentToks maps content stream operators to ItemOperator, and is used
+// instead of keytoks by content lexers. The object structure keywords mean
+// nothing in a content stream, but the basic object keywords still apply.
+var contentToks = map[string]ItemType{
+	"true":  ItemTrue,
+	"false": ItemFalse,
+	"null":  ItemNull,
+}
+
+func init() {
+	for _, op := range strings.Fields(contentOps) {
+		contentToks[op] = ItemOperator
+	}
 }
 
 const eof = -1
@@ -84,108 +210,564 @@ const eof = -1
 // stateFn represents the state of the scanner as a function that returns the next state.
 type stateFn func(*Lexer) stateFn
 
+// config holds the optional behaviour of a Lexer, set with Options. The zero
+// config imposes no limits and emits every item, which is the default.
+type config struct {
+	maxNameLen    int                 // longest Name in bytes, not counting the '/'
+	maxDepth      int                 // deepest combined nesting of arrays, dicts and procedures
+	maxTokenBytes int                 // longest string, name or body before giving up
+	skipGarbage   bool                // emit junk around the header and %%EOF
+	lazyValues    bool                // leave Val empty
+	skipTrivia    bool                // don't emit whitespace and comments
+	keywords      map[string]ItemType // added to or overriding the built in keywords
+}
+
+// An Option configures a Lexer.
+type Option func(*config)
+
+// WithStrict applies the implementation limits from Annex C that matter to a
+// lexer, currently just the 127 byte limit on the length of names.
+func WithStrict() Option {
+	return func(c *config) {
+		c.maxNameLen = 127
+	}
+}
+
+// WithMaxNameLen makes names longer than n bytes, not counting the '/', an
+// error.
+func WithMaxNameLen(n int) Option {
+	return func(c *config) {
+		c.maxNameLen = n
+	}
+}
+
+// WithMaxDepth makes nesting arrays, dicts and procedures more than n deep,
+// combined, an error.
+func WithMaxDepth(n int) Option {
+	return func(c *config) {
+		c.maxDepth = n
+	}
+}
+
+// WithMaxTokenBytes stops strings, hex strings, names and stream or inline
+// image bodies that run for more than n bytes without terminating, so that a
+// single token can't make the lexer scan the whole input. Strings, hex
+// strings and names count their delimiters, bodies are just the data.
+func WithMaxTokenBytes(n int) Option {
+	return func(c *config) {
+		c.maxTokenBytes = n
+	}
+}
+
+// WithSkipGarbage skips junk before the %PDF- header and after the final
+// %%EOF, emitting it as ItemPreamble and ItemPostamble, as conforming readers
+// do.
+func WithSkipGarbage() Option {
+	return func(c *config) {
+		c.skipGarbage = true
+	}
+}
+
+// WithLazyValues leaves Val empty in every item except ItemError. Items then
+// carry only their span and don't keep the input alive, and values are
+// fetched with Lexer.Value or Lexer.Slice when they're wanted. The Parser
+// fetches them itself. The Item methods and WriteItems need Val, so they
+// report lazy items as errors, or as not numbers.
+func WithLazyValues() Option {
+	return func(c *config) {
+		c.lazyValues = true
+	}
+}
+
+// WithSkipTrivia drops whitespace and comments in the lexer, so no
+// ItemSpace or ItemComment is ever emitted. Unlike filtering them with
+// SignificantItems, this saves the cost of sending them at all. The Parser
+// needs the whitespace to find indirect references, so it can't be used
+// with this option.
+func WithSkipTrivia() Option {
+	return func(c *config) {
+		c.skipTrivia = true
+	}
+}
+
+// WithKeywords adds the words in kw to the keywords the lexer recognises, or
+// overrides built in ones, for this lexer only. A word is emitted as the type
+// it maps to, which can be any ItemType, including ones the caller defines
+// above ItemNull. Mapping a built in keyword to ItemWord turns it off. The
+// map isn't modified, and isn't read after the lexer is created.
+func WithKeywords(kw map[string]ItemType) Option {
+	return func(c *config) {
+		if c.keywords == nil {
+			c.keywords = map[string]ItemType{}
+		}
+		for k, v := range kw {
+			c.keywords[k] = v
+		}
+	}
+}
+
+// keywordsFor returns base with any keywords from WithKeywords applied. base
+// is shared by every lexer, so it is copied rather than changed.
+func (c config) keywordsFor(base map[string]ItemType) map[string]ItemType {
+	if c.keywords == nil {
+		return base
+	}
+	kw := make(map[string]ItemType, len(base)+len(c.keywords))
+	for k, v := range base {
+		kw[k] = v
+	}
+	for k, v := range c.keywords {
+		kw[k] = v
+	}
+	return kw
+}
+
+// newConfig builds a config from opts.
+func newConfig(opts []Option) config {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
 // lexer holds the state of the scanner.
 type Lexer struct {
-	name       string    // the name of the input; used only for error reports
-	input      string    // the string being scanned
-	state      stateFn   // the next lexing function to enter
-	Pos        Pos       // current position in the input
-	Start      Pos       // start position of this item
-	Width      Pos       // width of last rune read from input
-	LastPos    Pos       // position of most recent item returned by nextItem
-	items      chan item // channel of scanned items
-	arrayDepth int       // nesting depth of [], <<>>
+	name       string              // the name of the input; used only for error reports
+	input      string              // the string being scanned
+	cfg        config              // optional behaviour
+	keywords   map[string]ItemType // keytoks, or contentToks for content streams, plus WithKeywords
+	content    bool                // lexing a content stream
+	state      stateFn             // the next lexing function to enter
+	Pos        Pos                 // current position in the input
+	Start      Pos                 // start position of this item
+	Width      Pos                 // width of last rune read from input
+	LastPos    Pos                 // position of most recent item returned by nextItem
+	items      chan Item           // channel of scanned items
+	started    bool                // the lexing goroutine has been started
+	fn         func(Item) bool     // receives items instead of the channel, see LexFunc
+	stopped    bool                // fn asked to stop
+	item       Item                // most recent item read by Scan
+	err        error               // error that stopped Scan, if any
+	done       bool                // Scan has reached ItemEOF or ItemError
+	final      Item                // the ItemEOF or ItemError that ended the scan
+	obj        string              // "N G" of the object being lexed, for errors
+	nums       [2]string           // the last two numbers, which may be N G
+	numRun     int                 // how many numbers in a row have been seen
+	arrayDepth int                 // nesting depth of [], <<>>, {}
 	dictDepth  int
+	braceDepth int
 }
 
 // next returns the next rune in the input.
 func (l *Lexer) next() rune {
-	if int(l.pos) >= len(l.input) {
-		l.width = 0
+	if int(l.Pos) >= len(l.input) {
+		l.Width = 0
 		return eof
 	}
-	r, w := utf8.DecodeRuneInString(l.input[l.pos:])
-	l.width = Pos(w)
-	l.pos += l.width
+	r, w := utf8.DecodeRuneInString(l.input[l.Pos:])
+	l.Width = Pos(w)
+	l.Pos += l.Width
 	return r
 }
 
-// peek returns but does not consume the next rune in the input.
+// peek returns but does not consume the next rune in the input. Width is
+// left as the width of the last rune consumed, so that a backup after a peek
+// still steps back over that rune, even if the peeked rune is a different
+// width or is eof.
 func (l *Lexer) peek() rune {
+	w := l.Width
 	r := l.next()
 	l.backup()
+	l.Width = w
 	return r
 }
 
 // backup steps back one rune. Must only be called once per call of next.
 func (l *Lexer) backup() {
-	l.pos -= l.width
+	l.Pos -= l.Width
 }
 
 // emit passes an item back to the client.
-func (l *Lexer) emit(t itemType) {
-	l.items <- item{t, l.start, l.input[l.start:l.pos]}
-	l.start = l.pos
+func (l *Lexer) emit(t ItemType) {
+	it := Item{Typ: t, Pos: l.Start, Depth: l.depth(), end: l.Pos}
+	if !l.cfg.lazyValues {
+		it.Val = l.input[l.Start:l.Pos]
+	}
+	l.track(t)
+	l.send(it)
+	l.Start = l.Pos
+}
+
+// send delivers an item to the client, over the channel or to the callback
+// set by LexFunc. Once the callback has asked to stop, items are dropped.
+func (l *Lexer) send(it Item) {
+	if l.fn == nil {
+		l.items <- it
+		return
+	}
+	if !l.stopped && !l.fn(it) {
+		l.stopped = true
+	}
 }
 
 // ignore skips over the pending input before this point.
 func (l *Lexer) ignore() {
-	l.start = l.pos
+	l.Start = l.Pos
 }
 
-// accept consumes the next rune if it's from the valid set.
+// accept consumes the next rune if it's from the valid set. eof is never
+// valid, and reading it consumes nothing, so there is nothing to back up.
 func (l *Lexer) accept(valid string) bool {
-	if strings.IndexRune(valid, l.next()) >= 0 {
+	r := l.next()
+	if r == eof {
+		return false
+	}
+	if strings.IndexRune(valid, r) >= 0 {
 		return true
 	}
 	l.backup()
 	return false
 }
 
-// acceptRun consumes a run of runes from the valid set.
+// acceptRun consumes a run of runes from the valid set, stopping cleanly at
+// the end of the input.
 func (l *Lexer) acceptRun(valid string) {
-	for strings.IndexRune(valid, l.next()) >= 0 {
+	for {
+		r := l.next()
+		if r == eof {
+			return
+		}
+		if strings.IndexRune(valid, r) < 0 {
+			l.backup()
+			return
+		}
 	}
-	l.backup()
 }
 
 // lineNumber reports which line we're on, based on the position of
 // the previous item returned by nextItem. Doing it this way
 // means we don't have to worry about peek double counting.
 func (l *Lexer) LineNumber() int {
-	return 1 + strings.Count(l.input[:l.lastPos], "\n")
+	return 1 + strings.Count(l.input[:l.LastPos], "\n")
+}
+
+// Slice returns the original input spanned by i. The result is a substring of
+// the input, so no copy is made. ItemError values are messages rather than
+// input, so they span nothing.
+func (l *Lexer) Slice(i Item) string {
+	if i.Typ == ItemError || i.Pos < 0 || int(i.End()) > len(l.input) {
+		return ""
+	}
+	return l.input[i.Pos:i.End()]
+}
+
+// WriteItems writes the values of items to w in order. Every item produced
+// by the lexer holds the exact input it spans, and together they cover the
+// whole input, so writing all the items from a scan reproduces the input
+// byte for byte, unless WithSkipTrivia dropped the whitespace and comments.
+// ItemError values are messages, not input, so they can't be written, and
+// nor can items from WithLazyValues, which have no value.
+func WriteItems(w io.Writer, items []Item) error {
+	for _, it := range items {
+		if it.Typ == ItemError {
+			return fmt.Errorf("can't write error item at %d: %s", it.Pos, it.Val)
+		}
+		if it.lazy() {
+			return fmt.Errorf("can't write lazy %v item at %d", it.Typ, it.Pos)
+		}
+		if _, err := io.WriteString(w, it.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Value returns the value of i. For a lexer using WithLazyValues, this is where
+// the value of an item is materialised from the input.
+func (l *Lexer) Value(i Item) string {
+	if i.Val != "" || i.Typ == ItemError {
+		return i.Val
+	}
+	return l.Slice(i)
+}
+
+// track follows object definitions through the emitted items, so that errors
+// can say which object they are in. Whitespace and comments may come between
+// N, G and obj.
+func (l *Lexer) track(t ItemType) {
+	switch t {
+	case ItemSpace, ItemComment:
+	case ItemNumber:
+		l.nums[0], l.nums[1] = l.nums[1], l.input[l.Start:l.Pos]
+		l.numRun++
+	case ItemObj:
+		if l.numRun >= 2 {
+			l.obj = l.nums[0] + " " + l.nums[1]
+		}
+		l.numRun = 0
+	case ItemEndObj:
+		l.obj = ""
+		l.numRun = 0
+	default:
+		l.numRun = 0
+	}
+}
+
+// context describes where the lexer is in the structure of the document, like
+// "dict depth 2, in object 7 0", or returns "" at the top level.
+func (l *Lexer) context() string {
+	var parts []string
+	if l.dictDepth > 0 {
+		parts = append(parts, fmt.Sprintf("dict depth %d", l.dictDepth))
+	}
+	if l.arrayDepth > 0 {
+		parts = append(parts, fmt.Sprintf("array depth %d", l.arrayDepth))
+	}
+	if l.braceDepth > 0 {
+		parts = append(parts, fmt.Sprintf("procedure depth %d", l.braceDepth))
+	}
+	if l.obj != "" {
+		parts = append(parts, "in object "+l.obj)
+	}
+	return strings.Join(parts, ", ")
 }
 
 // errorf returns an error token and terminates the scan by passing
 // back a nil pointer that will be the next state, terminating l.nextItem.
+// The message ends with the context, if any, in parens.
 func (l *Lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{itemError, l.start, fmt.Sprintf(format, args...)}
+	msg := fmt.Sprintf(format, args...)
+	if ctx := l.context(); ctx != "" {
+		msg += " (" + ctx + ")"
+	}
+	l.send(Item{Typ: ItemError, Pos: l.Start, Val: msg, Depth: l.depth()})
 	return nil
 }
 
-// nextItem returns the next item from the input.
-func (l *Lexer) NextItem() item {
-	item := <-l.items
-	l.lastPos = item.pos
+// nextItem returns the next item from the input. The first call starts the
+// lexing goroutine. Once the scan has ended, every later call returns the
+// same ItemEOF or ItemError that ended it.
+func (l *Lexer) NextItem() Item {
+	if !l.started {
+		l.started = true
+		go l.run()
+	}
+	item, ok := <-l.items
+	if !ok {
+		return l.final
+	}
+	if item.Typ == ItemEOF || item.Typ == ItemError {
+		l.final = item
+	}
+	l.LastPos = item.Pos
 	return item
 }
 
-// lex creates a new scanner for the input string.
-func NewLexer(name, input string) *Lexer {
-	l := &lexer{
-		name:  name,
-		input: input,
-		items: make(chan item),
+// Scan advances the lexer to the next item, which is then available via
+// Item. It returns false when the scan stops, either at ItemEOF or at an
+// ItemError, after which Err returns the error, if any. Scan is an
+// alternative to NextItem in the style of bufio.Scanner; don't mix the two.
+func (l *Lexer) Scan() bool {
+	if l.done {
+		return false
+	}
+	it := l.NextItem()
+	switch it.Typ {
+	case ItemEOF:
+		l.done = true
+		return false
+	case ItemError:
+		l.done = true
+		l.err = fmt.Errorf("%s:%d: %s", l.name, it.Pos, it.Val)
+		return false
 	}
-	go l.run()
+	l.item = it
+	return true
+}
+
+// Item returns the item read by the most recent call to Scan. It is only
+// valid after Scan has returned true.
+func (l *Lexer) Item() Item {
+	return l.item
+}
+
+// Err returns the error that stopped Scan, or nil if it stopped at ItemEOF.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// SignificantItems returns a function that returns the next item from l,
+// skipping the noise (ItemSpace and ItemComment) so that only structural
+// items are left. Positions are unchanged, so items still refer to the
+// original input. If keepMarker is true, the binary marker comment that
+// usually follows the header is kept, since it is the only comment with
+// meaning to readers.
+func SignificantItems(l *Lexer, keepMarker bool) func() Item {
+	return func() Item {
+		for {
+			it := l.NextItem()
+			switch {
+			case it.Typ == ItemSpace:
+			case it.Typ == ItemComment && !(keepMarker && isBinaryMarker(it)):
+			default:
+				return it
+			}
+		}
+	}
+}
+
+// Drain drains the output so the lexing goroutine will exit. Call it when
+// abandoning a Lexer before it has returned ItemEOF or ItemError.
+func (l *Lexer) Drain() {
+	if !l.started {
+		return
+	}
+	for item := range l.items {
+		if item.Typ == ItemEOF || item.Typ == ItemError {
+			l.final = item
+		}
+	}
+}
+
+// lex creates a new scanner for the input string. With no options it imposes
+// no limits and emits every item.
+func NewLexer(name, input string, opts ...Option) *Lexer {
+	l := newLexer(name, input, keytoks, opts)
+	l.items = make(chan Item)
 	return l
 }
 
+// NewLexerBytes is NewLexer for input that is already in memory as bytes,
+// such as a whole file from ioutil.ReadFile. The lexer uses input in place
+// instead of converting it to a string, which would copy the whole file.
+// Item values are substrings of input, so input must not be modified while
+// the lexer or any of its items are in use.
+func NewLexerBytes(name string, input []byte, opts ...Option) *Lexer {
+	return NewLexer(name, unsafe.String(unsafe.SliceData(input), len(input)), opts...)
+}
+
+// NewContentLexer creates a new scanner for a decoded page content stream.
+// Content stream operators such as BT, Tf and Tj are emitted as ItemOperator,
+// after their operands, and inline image data is emitted as ItemStreamBody.
+// Document structure keywords like obj and stream are just ItemWord here.
+// cf PDF3200_2008.pdf 7.8.2
+func NewContentLexer(name, input string, opts ...Option) *Lexer {
+	l := newLexer(name, input, contentToks, opts)
+	l.content = true
+	l.items = make(chan Item)
+	return l
+}
+
+// LexFunc lexes input synchronously on the calling goroutine, passing each
+// item to fn until fn returns false or the scan ends with ItemEOF or
+// ItemError. The items are exactly those NextItem would return for a Lexer
+// from NewLexer with the same options, but there is no goroutine, so nothing
+// to drain.
+func LexFunc(name, input string, fn func(Item) bool, opts ...Option) {
+	l := newLexer(name, input, keytoks, opts)
+	l.fn = fn
+	l.run()
+}
+
+// newLexer does the setup common to every constructor: it applies opts and
+// layers any WithKeywords over the built in table kw. The caller still has to
+// choose where items go, by setting either items or fn.
+func newLexer(name, input string, kw map[string]ItemType, opts []Option) *Lexer {
+	cfg := newConfig(opts)
+	return &Lexer{
+		name:     name,
+		input:    input,
+		cfg:      cfg,
+		keywords: cfg.keywordsFor(kw),
+	}
+}
+
+// Tokenize lexes all of input with the given options and returns the items,
+// not including the final ItemEOF. If lexing fails, the items before the
+// ItemError are returned along with an error giving its position and
+// message. It uses LexFunc, so there is no goroutine to leak.
+func Tokenize(name, input string, opts ...Option) ([]Item, error) {
+	var (
+		items []Item
+		err   error
+	)
+	LexFunc(name, input, func(it Item) bool {
+		switch it.Typ {
+		case ItemEOF:
+		case ItemError:
+			err = fmt.Errorf("%s:%d: %s", name, it.Pos, it.Val)
+		default:
+			items = append(items, it)
+		}
+		return true
+	}, opts...)
+	return items, err
+}
+
+// SeekTo makes the lexer start at pos instead of the beginning of the input,
+// for random access via offsets found in the document, like the startxref
+// offset or the entries of a cross-reference table. Lexing starts in the
+// default state, as if at the top level, so pos should be at the start of a
+// token. It is an error to seek once NextItem or Scan has been called, or to
+// seek outside the input.
+func (l *Lexer) SeekTo(pos Pos) error {
+	if l.started {
+		return fmt.Errorf("%s: can't seek after lexing has started", l.name)
+	}
+	if pos < 0 || int(pos) > len(l.input) {
+		return fmt.Errorf("%s: seek offset %d out of range", l.name, pos)
+	}
+	l.Pos, l.Start, l.LastPos = pos, pos, pos
+	return nil
+}
+
+// tooDeep reports whether opening another array or dict would exceed the
+// configured maximum nesting depth.
+func (l *Lexer) tooDeep() bool {
+	return l.cfg.maxDepth > 0 && l.depth() >= l.cfg.maxDepth
+}
+
+// depth returns the combined nesting depth of arrays, dicts and procedures.
+func (l *Lexer) depth() int {
+	return l.arrayDepth + l.dictDepth + l.braceDepth
+}
+
+// tooLong reports whether the pending item has grown past the configured
+// maximum token size.
+func (l *Lexer) tooLong() bool {
+	return l.cfg.maxTokenBytes > 0 && int(l.Pos-l.Start) > l.cfg.maxTokenBytes
+}
+
+// horizon returns how far a search for the end of the pending item may look:
+// the end of the input, or slack bytes past the maximum token size.
+func (l *Lexer) horizon(slack int) Pos {
+	if l.cfg.maxTokenBytes > 0 {
+		if h := l.Start + Pos(l.cfg.maxTokenBytes+slack); int(h) < len(l.input) {
+			return h
+		}
+	}
+	return Pos(len(l.input))
+}
+
+// errTooLong reports a token that exceeded the maximum token size.
+func (l *Lexer) errTooLong() stateFn {
+	return l.errorf("token exceeds limit of %d bytes", l.cfg.maxTokenBytes)
+}
+
 // run runs the state machine for the lexer.
 func (l *Lexer) run() {
-	for l.state = lexDefault; l.state != nil; {
+	l.state = lexDefault
+	// there's only a preamble at the very start of the input
+	if l.cfg.skipGarbage && l.Start == 0 {
+		l.state = lexPreamble
+	}
+	for l.state != nil && !l.stopped {
 		l.state = l.state(l)
 	}
+	if l.items != nil {
+		close(l.items)
+	}
 }
 
 // state functions
@@ -194,7 +776,7 @@ func (l *Lexer) run() {
 // namespace, as well as inside dicts <<>> and arrays [].
 func lexDefault(l *Lexer) stateFn {
 	switch r := l.next(); {
-	case unicode.IsSpace(r):
+	case isPDFWhitespace(r):
 		return lexSpace
 	case r == '/':
 		return lexName
@@ -204,43 +786,71 @@ func lexDefault(l *Lexer) stateFn {
 		// strings and hex objects have stricter rules
 	case isAlphaNumeric(r):
 		return lexWord
+	case l.content && (r == '\'' || r == '"'):
+		// the only operators that aren't made of alnums
+		l.emit(ItemOperator)
+		return lexDefault
 	case r == '(':
 		return lexStringObj
 	// dicts and arrays can nest arbitrarily deeply. We're not a parser, but
-	// let's just sanity check termination.
+	// let's just sanity check termination. "<<" always opens a dict, so any
+	// other '<', including the one in the empty hex string "<>", starts a
+	// hex string.
 	case r == '<':
 		if l.peek() == '<' {
+			if l.tooDeep() {
+				return l.errorf("nesting exceeds maximum depth %d", l.cfg.maxDepth)
+			}
 			l.backup()
-			l.dictDepth++
 			return lexLeftDict
 		}
 		return lexHexObj
 	// Arrays are just collections of objects, so all these default rules are still fine
 	case r == '[':
-		l.emit(itemLeftArray)
+		if l.tooDeep() {
+			return l.errorf("nesting exceeds maximum depth %d", l.cfg.maxDepth)
+		}
+		l.emit(ItemLeftArray)
 		l.arrayDepth++
 		return lexDefault
 	case r == ']':
-		l.arrayDepth--
-		if l.arrayDepth < 0 {
+		if l.arrayDepth == 0 {
 			return l.errorf("unexexpected array terminator")
 		}
-		l.emit(itemRightArray)
+		l.arrayDepth--
+		l.emit(ItemRightArray)
+		return lexDefault
+	// Braces only belong in the code of Type 4 functions, but the lexer
+	// doesn't know where it is, so they are always allowed here. The Parser
+	// can be stricter.
+	case r == '{':
+		if l.tooDeep() {
+			return l.errorf("nesting exceeds maximum depth %d", l.cfg.maxDepth)
+		}
+		l.emit(ItemLeftBrace)
+		l.braceDepth++
+		return lexDefault
+	case r == '}':
+		if l.braceDepth == 0 {
+			return l.errorf("unexpected '}'")
+		}
+		l.braceDepth--
+		l.emit(ItemRightBrace)
 		return lexDefault
 	case r == '%':
 		return lexComment
 	case r == '>':
 		if l.peek() == '>' {
-			l.dictDepth--
-			if l.dictDepth < 0 {
+			if l.dictDepth == 0 {
 				return l.errorf("unexexpected dict terminator")
 			}
+			l.dictDepth--
 			l.backup()
 			return lexRightDict
 		}
 		// '>' as part of a hex object should have been consumed in lexHex, so
 		// a stray '>' in this state is not valid.
-		fallthrough
+		return l.errorf("unexpected '>'")
 	case r == eof:
 		if l.arrayDepth > 0 {
 			return l.errorf("unterminated array")
@@ -248,61 +858,149 @@ func lexDefault(l *Lexer) stateFn {
 		if l.dictDepth > 0 {
 			return l.errorf("unterminated dict")
 		}
-		l.emit(itemEOF)
+		if l.braceDepth > 0 {
+			return l.errorf("unterminated procedure")
+		}
+		l.emit(ItemEOF)
 		return nil
 
 	default:
 		return l.errorf("illegal character: %#U", r)
 	}
+}
+
+// lexPreamble skips anything before the %PDF- header, which readers are
+// allowed to search for. If there is no header at all the input is lexed as
+// usual.
+// cf PDF3200_2008.pdf Annex H.3
+func lexPreamble(l *Lexer) stateFn {
+	i := strings.Index(l.input[l.Pos:], pdfHeader)
+	if i > 0 {
+		l.Pos += Pos(i)
+		l.emit(ItemPreamble)
+	}
+	return lexDefault
+}
+
+// lexPostamble is entered after a %%EOF comment when skipping garbage. If it
+// was the final %%EOF, anything left apart from whitespace is emitted as
+// ItemPostamble.
+func lexPostamble(l *Lexer) stateFn {
+	rest := l.input[l.Pos:]
+	if strings.Contains(rest, eofMarker) || strings.TrimFunc(rest, isPDFWhitespace) == "" {
+		return lexDefault
+	}
+	l.Pos = Pos(len(l.input))
+	l.emit(ItemPostamble)
 	return lexDefault
 }
 
 // lexStream quickly skips over all the contents of PDF stream objects. The
-// 'stream' header has already been consumed and emitted in lexWord.
+// 'stream' keyword has already been consumed in lexWord. It must be followed
+// by CRLF or a lone LF, which is emitted as part of the ItemStream marker so
+// that the body starts exactly where the stream data does.
+// cf PDF3200_2008.pdf 7.3.8.1
 func lexStream(l *Lexer) stateFn {
-	i := strings.Index(l.input[l.pos:], rightStream)
+	switch l.next() {
+	case '\r':
+		if !l.accept("\n") {
+			return l.errorf("stream keyword followed by bare CR")
+		}
+	case '\n':
+	default:
+		return l.errorf("stream keyword not followed by EOL")
+	}
+	l.emit(ItemStream)
+
+	h := l.horizon(len(rightStream))
+	i := strings.Index(l.input[l.Pos:h], rightStream)
 	if i < 0 {
+		if int(h) < len(l.input) {
+			return l.errTooLong()
+		}
 		return l.errorf("unclosed stream")
 	}
-	l.pos += Pos(i)
-	l.emit(itemStreamBody)
-	l.pos += Pos(len(rightStream))
-	l.emit(itemEndStream)
+	l.Pos += Pos(i)
+	l.emit(ItemStreamBody)
+	l.Pos += Pos(len(rightStream))
+	l.emit(ItemEndStream)
 	return lexDefault
 }
 
+// lexInlineImage skips the binary data of an inline image in a content
+// stream. The data starts after the single whitespace byte that follows the
+// ID operator, which is emitted along with the operator, and runs until an EI
+// operator that is preceded by whitespace.
+// cf PDF3200_2008.pdf 8.9.7
+func lexInlineImage(l *Lexer) stateFn {
+	if !isPDFWhitespace(l.next()) {
+		return l.errorf("ID operator not followed by whitespace")
+	}
+	l.emit(ItemOperator)
+	h := l.horizon(len(" EI"))
+	for i := l.Pos; ; {
+		j := strings.Index(l.input[i:h], "EI")
+		if j < 0 {
+			if int(h) < len(l.input) {
+				return l.errTooLong()
+			}
+			return l.errorf("unterminated inline image")
+		}
+		end := i + Pos(j)
+		after := end + Pos(len("EI"))
+		if end > l.Pos && isPDFWhitespace(rune(l.input[end-1])) &&
+			(int(after) == len(l.input) || isDelim(rune(l.input[after])) || isPDFWhitespace(rune(l.input[after]))) {
+			// leave the whitespace before EI to lexSpace
+			l.Pos = end - 1
+			l.emit(ItemStreamBody)
+			return lexDefault
+		}
+		i = after
+	}
+}
+
 // lexLeftDict scans the left delimiter, which is known to be present.
 func lexLeftDict(l *Lexer) stateFn {
-	l.pos += Pos(len(leftDict))
-	l.emit(itemLeftDict)
+	l.Pos += Pos(len(leftDict))
+	l.emit(ItemLeftDict)
+	l.dictDepth++
 	return lexDefault
 }
 
-// lexComment lexes a PDF comment from a comment marker % to the next EOL
-// marker. However, '\r\n' (specifically) is treated as one EOL marker. Some
-// comments such as %%EOF and %PDF-1.7 are special to reader software, but
-// that's parser business.
+// lexComment lexes a PDF comment from a comment marker % up to the next EOL
+// marker, which is left to be lexed as whitespace. Some comments such as
+// %%EOF and %PDF-1.7 are special to reader software, but that's parser
+// business.
 // cf PDF3200_2008.pdf 7.2.2
 func lexComment(l *Lexer) stateFn {
 
-	var r rune
-	for !isEndOfLine(l.peek()) {
-		r = l.next()
+	// comments are scanned as bytes, not runes. The binary marker comment
+	// after the header is deliberately not UTF-8, and nothing but the EOL
+	// bytes can end a comment, so there is nothing to decode. A comment can
+	// also run to the end of the input.
+	if i := strings.IndexAny(l.input[l.Pos:], "\r\n"); i >= 0 {
+		l.Pos += Pos(i)
+	} else {
+		l.Pos = Pos(len(l.input))
 	}
+	l.Width = 0
 
-	// any single EOL marker has been consumed above. Check for CRLF.
-	if r == '\r' {
-		l.accept("\n")
+	final := l.cfg.skipGarbage && strings.HasPrefix(l.input[l.Start:], eofMarker)
+	if l.cfg.skipTrivia {
+		l.ignore()
+	} else {
+		l.emit(ItemComment)
+	}
+	if final {
+		return lexPostamble
 	}
-
-	l.emit(itemComment)
 	return lexDefault
 }
 
 // lexRightDict scans the right delimiter, which is known to be present.
 func lexRightDict(l *Lexer) stateFn {
-	l.pos += Pos(len(rightDict))
-	l.emit(itemRightDict)
+	l.Pos += Pos(len(rightDict))
+	l.emit(ItemRightDict)
 	return lexDefault
 }
 
@@ -313,12 +1011,25 @@ func lexRightDict(l *Lexer) stateFn {
 func lexName(l *Lexer) stateFn {
 	for {
 		switch r := l.next(); {
-		case isDelim(r) || unicode.IsSpace(r) || r == eof:
+		case isDelim(r) || isPDFWhitespace(r) || r == eof:
+			// NUL is whitespace, so it ends the name here rather than being
+			// reported as a control character. It can't be part of a name
+			// anyway, not even as #00.
+			// cf PDF3200_2008.pdf 7.2.2 Table 1, 7.3.5
 			l.backup()
-			l.emit(itemName)
+			l.emit(ItemName)
 			return lexDefault
 		case 0x20 < r && r < 0x7f:
-			break
+			if l.cfg.maxNameLen > 0 && int(l.Pos-l.Start)-1 > l.cfg.maxNameLen {
+				return l.errorf("name exceeds maximum length %d", l.cfg.maxNameLen)
+			}
+			if l.tooLong() {
+				return l.errTooLong()
+			}
+		case r < 0x20 || r == 0x7f:
+			// DEL and any control character that isn't whitespace must
+			// always be written as a #XX escape
+			return l.errorf("unescaped control character in name: %#U", r)
 		default:
 			return l.errorf("illegal character in name: %#U", r)
 		}
@@ -334,16 +1045,23 @@ func lexName(l *Lexer) stateFn {
 func lexStringObj(l *Lexer) stateFn {
 	balance := 1
 	for {
-		switch r := l.next(); {
+		r := l.next()
+		if l.tooLong() {
+			return l.errTooLong()
+		}
+		switch {
 		case r == '\\':
-			// escaped parens don't count towards balance
-			l.accept("()")
+			// a backslash escapes whatever follows it, so an escaped paren
+			// doesn't count towards balance and an escaped backslash can't
+			// escape the next character in turn. An EOL here is a line
+			// continuation, which is the same as far as balance goes.
+			l.next()
 		case r == '(':
 			balance++
 		case r == ')':
 			balance--
 			if balance <= 0 {
-				l.emit(itemString)
+				l.emit(ItemString)
 				return lexDefault
 			}
 		case r == eof:
@@ -355,15 +1073,20 @@ func lexStringObj(l *Lexer) stateFn {
 
 // lexHexObj scans a hex string, which is any number of hexadecimal characters
 // or whitespace enclosed by '<' '>'. The '<' rune has already been consumed.
+// Any number includes none, so "<>" is a valid empty hex string.
 // cf PDF3200_2008.pdf 7.3.4.3
 func lexHexObj(l *Lexer) stateFn {
 	digits := "0123456789abcdefABCDEF"
 	for {
-		switch r := l.next(); {
-		case strings.IndexRune(digits, r) >= 0 || unicode.IsSpace(r):
+		r := l.next()
+		if l.tooLong() {
+			return l.errTooLong()
+		}
+		switch {
+		case strings.IndexRune(digits, r) >= 0 || isPDFWhitespace(r):
 			//
 		case r == '>':
-			l.emit(itemHexString)
+			l.emit(ItemHexString)
 			return lexDefault
 		case r == eof:
 			return l.errorf("unterminated hexstring")
@@ -376,38 +1099,44 @@ func lexHexObj(l *Lexer) stateFn {
 // lexSpace scans a run of space characters one of which has already been seen.
 // cf PDF3200_2008.pdf 7.2.2
 func lexSpace(l *Lexer) stateFn {
-	// This is more permissive than the spec, which doesn't mention U+0085
-	// (NEL), U+00A0 (NBSP)
-	for unicode.IsSpace(l.peek()) {
+	for isPDFWhitespace(l.peek()) {
 		l.next()
 	}
-	l.emit(itemSpace)
+	if l.cfg.skipTrivia {
+		l.ignore()
+	} else {
+		l.emit(ItemSpace)
+	}
 	return lexDefault
 }
 
 // lexWord scans a run of basic alnums, one of which has already been seen. It
 // will emit known tokens as their special types, call new state functions for
 // types that require special lexing, and, failing that, emit the run as a
-// catchall itemWord and then return to lexDefault
+// catchall ItemWord and then return to lexDefault
 func lexWord(l *Lexer) stateFn {
 
-	for isAlphaNumeric(l.peek()) {
+	// several content stream operators end in *, like T* and f*
+	for isAlphaNumeric(l.peek()) || (l.content && l.peek() == '*') {
 		l.next()
 	}
 
-	tok, found := keytoks[l.input[l.start:l.pos]]
+	word := l.input[l.Start:l.Pos]
+	tok, found := l.keywords[word]
 	if found {
-		// known token type, emit it
-		l.emit(tok)
-		switch tok {
-		case itemStream:
+		// stream is emitted by lexStream along with its EOL
+		if tok == ItemStream {
 			return lexStream
-		default:
-			return lexDefault
 		}
+		if l.content && word == "ID" && tok == ItemOperator {
+			return lexInlineImage
+		}
+		// known token type, emit it
+		l.emit(tok)
+		return lexDefault
 	}
 
-	l.emit(itemWord)
+	l.emit(ItemWord)
 	return lexDefault
 }
 
@@ -415,9 +1144,9 @@ func lexWord(l *Lexer) stateFn {
 // cf PDF3200_2008.pdf 7.3.3
 func lexNumber(l *Lexer) stateFn {
 	if !l.scanNumber() {
-		return l.errorf("bad number syntax: %q", l.input[l.start:l.pos])
+		return l.errorf("bad number syntax: %q", l.input[l.Start:l.Pos])
 	}
-	l.emit(itemNumber)
+	l.emit(ItemNumber)
 	return lexDefault
 }
 
@@ -425,18 +1154,51 @@ func (l *Lexer) scanNumber() bool {
 	// Optional leading sign.
 	l.accept("+-")
 	digits := "0123456789"
+	start := l.Pos
 	l.acceptRun(digits)
+	n := l.Pos - start
 	if l.accept(".") {
+		start = l.Pos
 		l.acceptRun(digits)
+		n += l.Pos - start
+	}
+	// A sign or a '.' on its own is not a number
+	if n == 0 {
+		l.next()
+		return false
 	}
 	// Next thing must be a delimeter, space char or eof
-	if isDelim(l.peek()) || unicode.IsSpace(l.peek()) || l.peek() == eof {
+	if isDelim(l.peek()) || isPDFWhitespace(l.peek()) || l.peek() == eof {
 		return true
 	}
 	l.next()
 	return false
 }
 
+// isBinaryMarker reports whether it is a comment containing at least four
+// bytes of 128 or more, which is how writers flag a file as binary.
+// cf PDF3200_2008.pdf 7.5.2
+func isBinaryMarker(it Item) bool {
+	if it.Typ != ItemComment {
+		return false
+	}
+	n := 0
+	for i := 0; i < len(it.Val); i++ {
+		if it.Val[i] >= 0x80 {
+			n++
+		}
+	}
+	return n >= 4
+}
+
+// isPDFWhitespace reports whether r is whitespace. Table 1 lists NUL, which
+// unicode.IsSpace doesn't, so that is added. Otherwise this is more permissive
+// than the spec, which doesn't mention \v, U+0085 (NEL) or U+00A0 (NBSP).
+// cf PDF3200_2008.pdf 7.2.2 Table 1
+func isPDFWhitespace(r rune) bool {
+	return r == 0 || unicode.IsSpace(r)
+}
+
 // isEndOfLine reports whether r is an end-of-line character.
 func isEndOfLine(r rune) bool {
 	return r == '\r' || r == '\n'
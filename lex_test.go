@@ -0,0 +1,906 @@
+// (c) Ben Nagy 2015
+
+package pdflex
+
+import (
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lexed is an item as the tests compare it, without position or depth.
+type lexed struct {
+	typ ItemType
+	val string
+}
+
+// lexAll returns the items from lexing in, up to and including the ItemEOF
+// or ItemError that ends the scan.
+func lexAll(in string, opts ...Option) []lexed {
+	return lexItems(NewLexer("test", in, opts...))
+}
+
+// lexItems is lexAll for a lexer that has already been made.
+func lexItems(l *Lexer) []lexed {
+	var out []lexed
+	for {
+		it := l.NextItem()
+		out = append(out, lexed{it.Typ, it.Val})
+		if it.Typ == ItemEOF || it.Typ == ItemError {
+			return out
+		}
+	}
+}
+
+type lexTest struct {
+	name  string
+	input string
+	want  []lexed
+}
+
+// runLexTests runs each test as a subtest, comparing every item.
+func runLexTests(t *testing.T, tests []lexTest, opts ...Option) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lexAll(test.input, opts...); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%q:\ngot  %v\nwant %v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+var tEOF = lexed{ItemEOF, ""}
+
+func TestNameControlChars(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"del", "/A\x7f", []lexed{{ItemError, "unescaped control character in name: U+007F"}}},
+		{"del inside", "/A\x7fB", []lexed{{ItemError, "unescaped control character in name: U+007F"}}},
+		{"soh", "/A\x01", []lexed{{ItemError, "unescaped control character in name: U+0001"}}},
+		{"escaped del", "/A#7F", []lexed{{ItemName, "/A#7F"}, tEOF}},
+		// NUL is whitespace (Table 1), so it ends the name like a space would
+		{"nul", "/A\x00", []lexed{{ItemName, "/A"}, {ItemSpace, "\x00"}, tEOF}},
+		{"tab", "/A\tB", []lexed{{ItemName, "/A"}, {ItemSpace, "\t"}, {ItemWord, "B"}, tEOF}},
+	})
+}
+
+func TestNextItemAfterEnd(t *testing.T) {
+	for _, in := range []string{"1 2", "1 )"} {
+		l := NewLexer("test", in)
+		var last Item
+		for last = l.NextItem(); last.Typ != ItemEOF && last.Typ != ItemError; last = l.NextItem() {
+		}
+		for i := 0; i < 3; i++ {
+			if it := l.NextItem(); it != last {
+				t.Errorf("%q: NextItem after the end returned %v, want %v", in, it, last)
+			}
+		}
+
+		// the final item survives a Drain too
+		l = NewLexer("test", in)
+		l.NextItem()
+		l.Drain()
+		if it := l.NextItem(); it != last {
+			t.Errorf("%q: NextItem after Drain returned %v, want %v", in, it, last)
+		}
+	}
+}
+
+func TestEmptyHexAndDict(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"empty hex", "<>", []lexed{{ItemHexString, "<>"}, tEOF}},
+		{"empty dict", "<<>>", []lexed{{ItemLeftDict, "<<"}, {ItemRightDict, ">>"}, tEOF}},
+		{"empty hex in dict", "<</A<>>>", []lexed{{ItemLeftDict, "<<"}, {ItemName, "/A"}, {ItemHexString, "<>"}, {ItemRightDict, ">>"}, tEOF}},
+		{"lone <", "<", []lexed{{ItemError, "unterminated hexstring"}}},
+	})
+}
+
+func TestBareR(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"bare R", "R", []lexed{{ItemWord, "R"}, tEOF}},
+		{"R after real", "1.5 R", []lexed{{ItemNumber, "1.5"}, {ItemSpace, " "}, {ItemWord, "R"}, tEOF}},
+		{"xref flags", "0000000000 65535 f", []lexed{{ItemNumber, "0000000000"}, {ItemSpace, " "}, {ItemNumber, "65535"}, {ItemSpace, " "}, {ItemWord, "f"}, tEOF}},
+	})
+}
+
+func TestLexFuncMatchesNextItem(t *testing.T) {
+	raw, err := ioutil.ReadFile("minimal.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{string(raw), "<< /A [1 2 R] >>", "1 )", ""} {
+		var want []Item
+		l := NewLexer("test", in)
+		for {
+			it := l.NextItem()
+			want = append(want, it)
+			if it.Typ == ItemEOF || it.Typ == ItemError {
+				break
+			}
+		}
+		var got []Item
+		LexFunc("test", in, func(it Item) bool {
+			got = append(got, it)
+			return true
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%.20q: LexFunc and NextItem disagree:\ngot  %v\nwant %v", in, got, want)
+		}
+	}
+}
+
+func TestLexFuncStop(t *testing.T) {
+	n := 0
+	LexFunc("test", "1 2 3 4 5", func(Item) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("fn called %d times, want it to stop after 3", n)
+	}
+}
+
+// FuzzLex feeds arbitrary input through every kind of lexer. Each scan must
+// end with ItemEOF or ItemError, and within a bounded number of items, since
+// every item but the last consumes at least one byte.
+func FuzzLex(f *testing.F) {
+	raw, err := ioutil.ReadFile("minimal.pdf")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(string(raw))
+	for _, s := range []string{
+		"%a", "<", "(\\", "/A#", "1 0 obj << /Length 9 >> stream\nabc",
+		"BT /F1 12 Tf (x) Tj ET BI /W 1 ID x EI", "{ 1 } >> ] }",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		check := func(l *Lexer) {
+			var it Item
+			for n := 0; ; n++ {
+				if n > len(in)+1 {
+					t.Fatalf("%q: no end after %d items", in, n)
+				}
+				it = l.NextItem()
+				if it.Typ == ItemString || it.Typ == ItemHexString {
+					it.Text()
+				}
+				if it.Typ == ItemEOF || it.Typ == ItemError {
+					return
+				}
+			}
+		}
+		check(NewLexer("fuzz", in))
+		check(NewContentLexer("fuzz", in))
+		check(NewLexer("fuzz", in, WithSkipGarbage(), WithSkipTrivia(), WithMaxDepth(3), WithMaxNameLen(5)))
+	})
+}
+
+func TestCommentAtEOF(t *testing.T) {
+	// found by FuzzLex: this used to spin forever
+	runLexTests(t, []lexTest{
+		{"short", "%a", []lexed{{ItemComment, "%a"}, tEOF}},
+		{"comment", "%a comment", []lexed{{ItemComment, "%a comment"}, tEOF}},
+		{"after obj", "1 0 obj\n%a comment", []lexed{
+			{ItemNumber, "1"}, {ItemSpace, " "}, {ItemNumber, "0"}, {ItemSpace, " "}, {ItemObj, "obj"},
+			{ItemSpace, "\n"}, {ItemComment, "%a comment"}, tEOF,
+		}},
+		{"just %", "%", []lexed{{ItemComment, "%"}, tEOF}},
+		{"EOF marker", "%%EOF", []lexed{{ItemComment, "%%EOF"}, tEOF}},
+	})
+	// with the trivia skipped there is nothing but the EOF
+	runLexTests(t, []lexTest{{"skipped", "%a comment", []lexed{tEOF}}}, WithSkipTrivia())
+}
+
+func TestLazyValues(t *testing.T) {
+	in := "1 0 obj << /A [1 2 R] /B (x\\)y) /C <41> /D 3.5 >> endobj"
+	l := NewLexer("test", in, WithLazyValues())
+	var items []Item
+	for it := l.NextItem(); it.Typ != ItemEOF; it = l.NextItem() {
+		if it.Val != "" {
+			t.Fatalf("%v item at %d has Val %q", it.Typ, it.Pos, it.Val)
+		}
+		if it.Typ == ItemError {
+			t.Fatal(it)
+		}
+		items = append(items, it)
+	}
+	for _, it := range items {
+		switch it.Typ {
+		case ItemString, ItemHexString:
+			if _, err := it.Decode(); err == nil {
+				t.Errorf("Decode of lazy %v item succeeded", it.Typ)
+			}
+		case ItemNumber:
+			if _, ok := it.Int(); ok {
+				t.Errorf("Int of lazy %v item succeeded", it.Typ)
+			}
+			if _, ok := it.Float(); ok {
+				t.Errorf("Float of lazy %v item succeeded", it.Typ)
+			}
+		}
+	}
+	if err := WriteItems(ioutil.Discard, items); err == nil {
+		t.Error("WriteItems of lazy items succeeded")
+	}
+
+	want, err := NewParser(NewLexer("test", in)).ParseIndirect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewParser(NewLexer("test", in, WithLazyValues())).ParseIndirect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lazy parse got %#v, want %#v", got, want)
+	}
+}
+
+// keepNames lexes a copy of in and returns its names, as an index of a file
+// might, dropping everything else.
+func keepNames(in string, opts ...Option) []Item {
+	l := NewLexerBytes("test", []byte(in), opts...)
+	var names []Item
+	for l.Scan() {
+		if it := l.Item(); it.Typ == ItemName {
+			names = append(names, it)
+		}
+	}
+	return names
+}
+
+func TestLazyValuesDontPinInput(t *testing.T) {
+	in := strings.Repeat("/A 1 ", 1<<16)
+	pinned := func(opts ...Option) bool {
+		buf := []byte(in)
+		freed := make(chan bool)
+		runtime.SetFinalizer(&buf[0], func(*byte) { close(freed) })
+		l := NewLexerBytes("test", buf, opts...)
+		var names []Item
+		for l.Scan() {
+			if it := l.Item(); it.Typ == ItemName {
+				names = append(names, it)
+			}
+		}
+		buf, l = nil, nil
+		defer runtime.KeepAlive(names)
+		for i := 0; i < 10; i++ {
+			runtime.GC()
+			select {
+			case <-freed:
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+	if !pinned() {
+		t.Error("items with values didn't keep the input alive, so this test proves nothing")
+	}
+	if pinned(WithLazyValues()) {
+		t.Error("lazy items kept the input alive")
+	}
+}
+
+// benchmarkLex reports the heap still in use per op by the names kept from
+// lexing a fresh copy of a file. Lazy names don't keep the copy alive, so
+// each op retains about the size of the input less. The time and
+// allocations of the scan itself are the same either way.
+func benchmarkLex(b *testing.B, opts ...Option) {
+	raw, err := ioutil.ReadFile("minimal.pdf")
+	if err != nil {
+		b.Fatal(err)
+	}
+	in := strings.Repeat(string(raw), 100)
+	kept := make([][]Item, 0, b.N)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kept = append(kept, keepNames(in, opts...))
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "retained-B/op")
+	runtime.KeepAlive(kept)
+}
+
+func BenchmarkLex(b *testing.B)           { benchmarkLex(b) }
+func BenchmarkLexLazyValues(b *testing.B) { benchmarkLex(b, WithLazyValues()) }
+
+func TestNULSeparators(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"numbers", "1\x002", []lexed{{ItemNumber, "1"}, {ItemSpace, "\x00"}, {ItemNumber, "2"}, tEOF}},
+		{"padding", "1 0 obj\x00\x00\x00<<>>", []lexed{
+			{ItemNumber, "1"}, {ItemSpace, " "}, {ItemNumber, "0"}, {ItemSpace, " "}, {ItemObj, "obj"},
+			{ItemSpace, "\x00\x00\x00"}, {ItemLeftDict, "<<"}, {ItemRightDict, ">>"}, tEOF,
+		}},
+		{"mixed", "/A\x00\r\n\x00(x)", []lexed{{ItemName, "/A"}, {ItemSpace, "\x00\r\n\x00"}, {ItemString, "(x)"}, tEOF}},
+		{"word", "true\x00null", []lexed{{ItemTrue, "true"}, {ItemSpace, "\x00"}, {ItemNull, "null"}, tEOF}},
+	})
+}
+
+func TestStringEscapes(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"escaped backslash", `(\\)`, []lexed{{ItemString, `(\\)`}, tEOF}},
+		{"escaped paren", `(\))`, []lexed{{ItemString, `(\))`}, tEOF}},
+		{"backslash inside", `(a\\b)`, []lexed{{ItemString, `(a\\b)`}, tEOF}},
+		{"backslash then paren", `(\\\))`, []lexed{{ItemString, `(\\\))`}, tEOF}},
+		{"escaped backslash ends", `(\\) 1`, []lexed{{ItemString, `(\\)`}, {ItemSpace, " "}, {ItemNumber, "1"}, tEOF}},
+		{"continuation", "(a\\\nb)", []lexed{{ItemString, "(a\\\nb)"}, tEOF}},
+		{"escaped close only", `(\)`, []lexed{{ItemError, "unterminated string object"}}},
+		{"trailing backslash", `(\`, []lexed{{ItemError, "unterminated string object"}}},
+	})
+
+	for in, want := range map[string]string{`(\\)`: `\`, `(\))`: ")", `(a\\b)`: `a\b`, "(a\\\nb)": "ab"} {
+		it := lexAll(in)[0]
+		if got, err := (Item{Typ: it.typ, Val: it.val}).Decode(); err != nil || got != want {
+			t.Errorf("%q: Decode = %q, %v, want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestBinaryMarker(t *testing.T) {
+	in := "%PDF-1.7\n%\xe2\xe3\xcf\xd3\r\n1 0 obj"
+	runLexTests(t, []lexTest{
+		{"after header", in, []lexed{
+			{ItemComment, "%PDF-1.7"}, {ItemSpace, "\n"}, {ItemComment, "%\xe2\xe3\xcf\xd3"}, {ItemSpace, "\r\n"},
+			{ItemNumber, "1"}, {ItemSpace, " "}, {ItemNumber, "0"}, {ItemSpace, " "}, {ItemObj, "obj"}, tEOF,
+		}},
+		{"invalid UTF-8 at EOF", "%\xff\xfe\x80\x81", []lexed{{ItemComment, "%\xff\xfe\x80\x81"}, tEOF}},
+	})
+
+	next := SignificantItems(NewLexer("test", in), true)
+	if it := next(); it.Typ != ItemComment || it.Val != "%\xe2\xe3\xcf\xd3" || it.Pos != 9 {
+		t.Errorf("SignificantItems kept %v, want the marker at 9", it)
+	}
+	next = SignificantItems(NewLexer("test", in), false)
+	if it := next(); it.Typ != ItemNumber {
+		t.Errorf("SignificantItems returned %v, want the marker dropped", it)
+	}
+}
+
+func TestUnbalancedCloserDepth(t *testing.T) {
+	for _, in := range []string{"]", "}", ">>", "1 0 obj ]", "[ ] ]", "<< >> >>", "{ } }"} {
+		l := NewLexer("test", in)
+		it := l.NextItem()
+		for ; it.Typ != ItemEOF && it.Typ != ItemError; it = l.NextItem() {
+		}
+		if it.Typ != ItemError || it.Depth != 0 {
+			t.Errorf("%q: got %v at depth %d, want an error at depth 0", in, it, it.Depth)
+		}
+	}
+}
+
+func TestNewLexerBytes(t *testing.T) {
+	raw, err := ioutil.ReadFile("minimal.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range [][]byte{raw, nil, {}} {
+		want := lexAll(string(in))
+		l := NewLexerBytes("test", in)
+		var got []lexed
+		for l.Scan() {
+			got = append(got, lexed{l.Item().Typ, l.Item().Val})
+		}
+		got = append(got, tEOF)
+		if l.Err() != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("NewLexerBytes and NewLexer disagree: %v", l.Err())
+		}
+	}
+}
+
+// BenchmarkNewLexerBytes compares creating a lexer on a large file in place
+// with converting the file to a string first.
+func BenchmarkNewLexerBytes(b *testing.B) {
+	raw := make([]byte, 1<<20)
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewLexerBytes("bench", raw)
+		}
+	})
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewLexer("bench", string(raw))
+		}
+	})
+}
+
+func TestTokenAtEOF(t *testing.T) {
+	tests := []struct {
+		input string
+		typ   ItemType
+		val   string // the first item, which runs to EOF
+		next  lexed
+	}{
+		{"<<", ItemLeftDict, "<<", lexed{ItemError, "unterminated dict (dict depth 1)"}},
+		{"/Name", ItemName, "/Name", tEOF},
+		{"/", ItemName, "/", tEOF},
+		{"3.14", ItemNumber, "3.14", tEOF},
+		{"-5", ItemNumber, "-5", tEOF},
+		{"<48>", ItemHexString, "<48>", tEOF},
+		{"(x)", ItemString, "(x)", tEOF},
+		{"null", ItemNull, "null", tEOF},
+		{" \r\n", ItemSpace, " \r\n", tEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			l := NewLexer("test", test.input)
+			it := l.NextItem()
+			if it.Typ != test.typ || it.Val != test.val || it.Pos != 0 || l.Slice(it) != test.val {
+				t.Errorf("got %v spanning [%d, %d), want %v %q spanning [0, %d)",
+					it, it.Pos, it.End(), test.typ, test.val, len(test.val))
+			}
+			end := l.NextItem()
+			if got := (lexed{end.Typ, end.Val}); got != test.next || end.Pos != Pos(len(test.input)) {
+				t.Errorf("then got %v at %d, want %v at %d", end, end.Pos, test.next, len(test.input))
+			}
+		})
+	}
+}
+
+func TestLoneGreaterThan(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"alone", ">", []lexed{{ItemError, "unexpected '>'"}}},
+		{"mid file", "1 0 obj\n> endobj", []lexed{
+			{ItemNumber, "1"}, {ItemSpace, " "}, {ItemNumber, "0"}, {ItemSpace, " "}, {ItemObj, "obj"},
+			{ItemSpace, "\n"}, {ItemError, "unexpected '>' (in object 1 0)"},
+		}},
+		{"in dict", "<< /A > >>", []lexed{
+			{ItemLeftDict, "<<"}, {ItemSpace, " "}, {ItemName, "/A"}, {ItemSpace, " "},
+			{ItemError, "unexpected '>' (dict depth 1)"},
+		}},
+		{"after hex", "<41>>", []lexed{{ItemHexString, "<41>"}, {ItemError, "unexpected '>'"}}},
+	})
+
+	// the error is at the '>', not at the end of the input
+	l := NewLexer("test", "1 > 2")
+	for it := l.NextItem(); it.Typ != ItemEOF; it = l.NextItem() {
+		if it.Typ == ItemError {
+			if it.Pos != 2 {
+				t.Errorf("error at %d, want 2", it.Pos)
+			}
+			return
+		}
+	}
+	t.Error("no error for a lone '>'")
+}
+
+func TestErrorContext(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"top level", "1 )", "illegal character: U+0029 ')'"},
+		{"nested", "7 0 obj << /A << /B [ 1 ) ] >> >> endobj", "illegal character: U+0029 ')' (dict depth 2, array depth 1, in object 7 0)"},
+		{"procedure", "<< /F { 1 { ) } } >>", "illegal character: U+0029 ')' (dict depth 1, procedure depth 2)"},
+		{"space in header", "12 3\n%c\nobj [ )", "illegal character: U+0029 ')' (array depth 1, in object 12 3)"},
+		{"after endobj", "7 0 obj null endobj )", "illegal character: U+0029 ')'"},
+		{"unterminated", "7 0 obj [ [", "unterminated array (array depth 2, in object 7 0)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := lexAll(test.input)
+			if last := got[len(got)-1]; last != (lexed{ItemError, test.want}) {
+				t.Errorf("%q: got %v, want error %q", test.input, last, test.want)
+			}
+		})
+	}
+
+	// Tokenize adds the name and position
+	_, err := Tokenize("test.pdf", "7 0 obj [ )")
+	if want := "test.pdf:10: illegal character: U+0029 ')' (array depth 1, in object 7 0)"; err == nil || err.Error() != want {
+		t.Errorf("Tokenize error %v, want %q", err, want)
+	}
+}
+
+var (
+	tSpace = lexed{ItemSpace, " "}
+	tNL    = lexed{ItemSpace, "\n"}
+)
+
+// goldenTests pin the complete item sequence for small inputs covering every
+// kind of token and the main error paths.
+var goldenTests = []lexTest{
+	{"minimal dict", "<< /Type /Catalog /Pages 2 0 R >>", []lexed{
+		{ItemLeftDict, "<<"}, tSpace, {ItemName, "/Type"}, tSpace, {ItemName, "/Catalog"}, tSpace,
+		{ItemName, "/Pages"}, tSpace, {ItemNumber, "2"}, tSpace, {ItemNumber, "0"}, tSpace, {ItemWord, "R"}, tSpace,
+		{ItemRightDict, ">>"}, tEOF,
+	}},
+	{"nested arrays", "[[1] [[2]] []]", []lexed{
+		{ItemLeftArray, "["}, {ItemLeftArray, "["}, {ItemNumber, "1"}, {ItemRightArray, "]"}, tSpace,
+		{ItemLeftArray, "["}, {ItemLeftArray, "["}, {ItemNumber, "2"}, {ItemRightArray, "]"}, {ItemRightArray, "]"}, tSpace,
+		{ItemLeftArray, "["}, {ItemRightArray, "]"}, {ItemRightArray, "]"}, tEOF,
+	}},
+	{"strings", "(a\\(b\\)c\\n\\101) <48 65\n6c> (nested (parens))", []lexed{
+		{ItemString, "(a\\(b\\)c\\n\\101)"}, tSpace, {ItemHexString, "<48 65\n6c>"}, tSpace,
+		{ItemString, "(nested (parens))"}, tEOF,
+	}},
+	{"stream", "1 0 obj\n<< /Length 6 >>\nstream\nhello\nendstream\nendobj", []lexed{
+		{ItemNumber, "1"}, tSpace, {ItemNumber, "0"}, tSpace, {ItemObj, "obj"}, tNL,
+		{ItemLeftDict, "<<"}, tSpace, {ItemName, "/Length"}, tSpace, {ItemNumber, "6"}, tSpace, {ItemRightDict, ">>"}, tNL,
+		{ItemStream, "stream\n"}, {ItemStreamBody, "hello\n"}, {ItemEndStream, "endstream"}, tNL,
+		{ItemEndObj, "endobj"}, tEOF,
+	}},
+	{"stream CRLF", "<< /Length 3 >>stream\r\nab\nendstream", []lexed{
+		{ItemLeftDict, "<<"}, tSpace, {ItemName, "/Length"}, tSpace, {ItemNumber, "3"}, tSpace, {ItemRightDict, ">>"},
+		{ItemStream, "stream\r\n"}, {ItemStreamBody, "ab\n"}, {ItemEndStream, "endstream"}, tEOF,
+	}},
+	{"comments", "% c1\n%c2\r\n", []lexed{
+		{ItemComment, "% c1"}, tNL, {ItemComment, "%c2"}, {ItemSpace, "\r\n"}, tEOF,
+	}},
+	{"names", "/A#20B /#2F /a.b-c_d", []lexed{
+		{ItemName, "/A#20B"}, tSpace, {ItemName, "/#2F"}, tSpace, {ItemName, "/a.b-c_d"}, tEOF,
+	}},
+	{"numbers", "1 -2 +3 .5 -.5 4. 0.0", []lexed{
+		{ItemNumber, "1"}, tSpace, {ItemNumber, "-2"}, tSpace, {ItemNumber, "+3"}, tSpace, {ItemNumber, ".5"}, tSpace,
+		{ItemNumber, "-.5"}, tSpace, {ItemNumber, "4."}, tSpace, {ItemNumber, "0.0"}, tEOF,
+	}},
+	{"keywords", "true false null", []lexed{
+		{ItemTrue, "true"}, tSpace, {ItemFalse, "false"}, tSpace, {ItemNull, "null"}, tEOF,
+	}},
+	{"xref and trailer", "xref\n0 1\n0000000000 65535 f \ntrailer\n<< /Size 1 >>\nstartxref\n9\n%%EOF\n", []lexed{
+		{ItemXref, "xref"}, tNL, {ItemNumber, "0"}, tSpace, {ItemNumber, "1"}, tNL,
+		{ItemNumber, "0000000000"}, tSpace, {ItemNumber, "65535"}, tSpace, {ItemWord, "f"}, {ItemSpace, " \n"},
+		{ItemTrailer, "trailer"}, tNL,
+		{ItemLeftDict, "<<"}, tSpace, {ItemName, "/Size"}, tSpace, {ItemNumber, "1"}, tSpace, {ItemRightDict, ">>"}, tNL,
+		{ItemStartXref, "startxref"}, tNL, {ItemNumber, "9"}, tNL, {ItemComment, "%%EOF"}, tNL, tEOF,
+	}},
+
+	{"unterminated string", "(abc", []lexed{{ItemError, "unterminated string object"}}},
+	{"bad hex", "<4G>", []lexed{{ItemError, "illegal character in hexstring: U+0047 'G'"}}},
+	{"bad number", "1..2", []lexed{{ItemError, `bad number syntax: "1.."`}}},
+	{"lone sign", "--1", []lexed{{ItemError, `bad number syntax: "--"`}}},
+	{"illegal character", "@", []lexed{{ItemError, "illegal character: U+0040 '@'"}}},
+	{"unterminated dict", "<< /A 1", []lexed{
+		{ItemLeftDict, "<<"}, tSpace, {ItemName, "/A"}, tSpace, {ItemNumber, "1"},
+		{ItemError, "unterminated dict (dict depth 1)"},
+	}},
+	{"unterminated array", "[ 1", []lexed{
+		{ItemLeftArray, "["}, tSpace, {ItemNumber, "1"}, {ItemError, "unterminated array (array depth 1)"},
+	}},
+	{"unclosed stream", "1 0 obj\nstream\nabc", []lexed{
+		{ItemNumber, "1"}, tSpace, {ItemNumber, "0"}, tSpace, {ItemObj, "obj"}, tNL,
+		{ItemStream, "stream\n"}, {ItemError, "unclosed stream (in object 1 0)"},
+	}},
+	{"stream bare CR", "<< >>stream\rabc", []lexed{
+		{ItemLeftDict, "<<"}, tSpace, {ItemRightDict, ">>"}, {ItemError, "stream keyword followed by bare CR"},
+	}},
+	{"stream no EOL", "<< >>stream abc", []lexed{
+		{ItemLeftDict, "<<"}, tSpace, {ItemRightDict, ">>"}, {ItemError, "stream keyword not followed by EOL"},
+	}},
+	{"high byte in name", "/A\xc3\xa9", []lexed{{ItemError, "illegal character in name: U+00E9 'é'"}}},
+	{"invalid UTF-8 in name", "/A\x80", []lexed{{ItemError, "illegal character in name: U+FFFD '\ufffd'"}}},
+	{"stray ]", "1 ]", []lexed{{ItemNumber, "1"}, tSpace, {ItemError, "unexexpected array terminator"}}},
+	{"stray >>", "1 >>", []lexed{{ItemNumber, "1"}, tSpace, {ItemError, "unexexpected dict terminator"}}},
+	{"stray }", "1 }", []lexed{{ItemNumber, "1"}, tSpace, {ItemError, "unexpected '}'"}}},
+	{"stray >", "1 >", []lexed{{ItemNumber, "1"}, tSpace, {ItemError, "unexpected '>'"}}},
+}
+
+// goldenContentTests are goldenTests for content streams.
+var goldenContentTests = []lexTest{
+	{"operators", "q 1 0 0 1 0 0 cm BT /F1 12 Tf (a) Tj T* (b) ' ET Q", []lexed{
+		{ItemOperator, "q"}, tSpace, {ItemNumber, "1"}, tSpace, {ItemNumber, "0"}, tSpace, {ItemNumber, "0"}, tSpace,
+		{ItemNumber, "1"}, tSpace, {ItemNumber, "0"}, tSpace, {ItemNumber, "0"}, tSpace, {ItemOperator, "cm"}, tSpace,
+		{ItemOperator, "BT"}, tSpace, {ItemName, "/F1"}, tSpace, {ItemNumber, "12"}, tSpace, {ItemOperator, "Tf"}, tSpace,
+		{ItemString, "(a)"}, tSpace, {ItemOperator, "Tj"}, tSpace, {ItemOperator, "T*"}, tSpace,
+		{ItemString, "(b)"}, tSpace, {ItemOperator, "'"}, tSpace, {ItemOperator, "ET"}, tSpace, {ItemOperator, "Q"}, tEOF,
+	}},
+	{"inline image", "BI /W 1 ID \xffEI EI", []lexed{
+		{ItemOperator, "BI"}, tSpace, {ItemName, "/W"}, tSpace, {ItemNumber, "1"}, tSpace,
+		{ItemOperator, "ID "}, {ItemStreamBody, "\xffEI"}, tSpace, {ItemOperator, "EI"}, tEOF,
+	}},
+	{"unterminated inline image", "BI ID abc", []lexed{
+		{ItemOperator, "BI"}, tSpace, {ItemOperator, "ID "}, {ItemError, "unterminated inline image"},
+	}},
+	{"ID without whitespace", "BI ID(", []lexed{
+		{ItemOperator, "BI"}, tSpace, {ItemError, "ID operator not followed by whitespace"},
+	}},
+}
+
+// goldenGarbageTests are goldenTests for WithSkipGarbage.
+var goldenGarbageTests = []lexTest{
+	{"preamble and postamble", "junk%PDF-1.4\n%%EOF\njunk", []lexed{
+		{ItemPreamble, "junk"}, {ItemComment, "%PDF-1.4"}, tNL, {ItemComment, "%%EOF"}, {ItemPostamble, "\njunk"}, tEOF,
+	}},
+}
+
+func TestGolden(t *testing.T) {
+	runLexTests(t, goldenTests)
+	runLexTests(t, goldenGarbageTests, WithSkipGarbage())
+	for _, test := range goldenContentTests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lexItems(NewContentLexer("test", test.input)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%q:\ngot  %v\nwant %v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+// TestWriteItems checks that writing the items from a scan gives back the
+// input exactly.
+func TestWriteItems(t *testing.T) {
+	raw, err := ioutil.ReadFile("minimal.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []string{string(raw), ""}
+	for _, test := range goldenTests {
+		if last := test.want[len(test.want)-1]; last.typ != ItemError {
+			inputs = append(inputs, test.input)
+		}
+	}
+	for _, in := range inputs {
+		items, err := Tokenize("test", in)
+		if err != nil {
+			t.Fatalf("%.20q: %v", in, err)
+		}
+		var b strings.Builder
+		if err := WriteItems(&b, items); err != nil {
+			t.Fatalf("%.20q: %v", in, err)
+		}
+		if b.String() != in {
+			t.Errorf("%.20q: wrote %.20q", in, b.String())
+		}
+	}
+
+	// but not when the trivia is skipped
+	items, err := Tokenize("test", "1 %c\n2", WithSkipTrivia())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := WriteItems(&b, items); err != nil || b.String() != "12" {
+		t.Errorf("WithSkipTrivia wrote %q, %v, want \"12\"", b.String(), err)
+	}
+
+	if err := WriteItems(&b, []Item{{Typ: ItemError, Val: "oops"}}); err == nil {
+		t.Error("WriteItems wrote an ItemError")
+	}
+}
+
+func TestPeekAtEOF(t *testing.T) {
+	check := func(l *Lexer, pos, width Pos) {
+		t.Helper()
+		if l.Pos != pos || l.Width != width {
+			t.Errorf("%q: Pos %d, Width %d, want %d, %d", l.input, l.Pos, l.Width, pos, width)
+		}
+	}
+
+	l := NewLexer("test", "a")
+	l.next()
+	if r := l.peek(); r != eof {
+		t.Errorf("peek = %q, want eof", r)
+	}
+	check(l, 1, 1)
+	l.backup()
+	check(l, 0, 1)
+
+	// backing up after reading eof is a no-op, since eof has no width
+	l = NewLexer("test", "a")
+	l.next()
+	l.next()
+	check(l, 1, 0)
+	l.backup()
+	check(l, 1, 0)
+
+	// a multibyte rune is backed up whole, even after a peek at eof
+	l = NewLexer("test", "é")
+	l.next()
+	l.peek()
+	l.peek()
+	check(l, 2, 2)
+	l.backup()
+	check(l, 0, 2)
+
+	// accept and acceptRun leave Pos at the end of what they consumed
+	l = NewLexer("test", "12")
+	l.acceptRun("0123456789")
+	check(l, 2, 0)
+	if l.accept("0123456789") {
+		t.Error("accept consumed eof")
+	}
+	check(l, 2, 0)
+}
+
+// TestSpansAtEOF checks that items from each state function tile the input
+// exactly when the input ends inside them.
+func TestSpansAtEOF(t *testing.T) {
+	for _, in := range []string{"1", "1 ", "-.5", "/A", "/A#20", "%c", " \t\r\n", "(x)", "<41>", "true", "<< /A 1 >>", "é%"} {
+		items, err := Tokenize("test", in)
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+			continue
+		}
+		var pos Pos
+		for _, it := range items {
+			if it.Pos != pos || in[it.Pos:it.End()] != it.Val {
+				t.Errorf("%q: %v spans [%d, %d), want it to start at %d", in, it, it.Pos, it.End(), pos)
+			}
+			pos = it.End()
+		}
+		if int(pos) != len(in) {
+			t.Errorf("%q: items end at %d, want %d", in, pos, len(in))
+		}
+	}
+}
+
+func TestSkipGarbage(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"preamble", "@@ junk\n%PDF-1.4\n", []lexed{
+			{ItemPreamble, "@@ junk\n"}, {ItemComment, "%PDF-1.4"}, tNL, tEOF,
+		}},
+		{"postamble", "%%EOF\r\n@@ junk", []lexed{{ItemComment, "%%EOF"}, {ItemPostamble, "\r\n@@ junk"}, tEOF}},
+		{"trailing whitespace", "%%EOF\r\n", []lexed{{ItemComment, "%%EOF"}, {ItemSpace, "\r\n"}, tEOF}},
+		{"NUL padding", "%%EOF\r\n\x00\x00", []lexed{{ItemComment, "%%EOF"}, {ItemSpace, "\r\n\x00\x00"}, tEOF}},
+		{"not the final EOF", "%%EOF\n1 %%EOF\n@", []lexed{
+			{ItemComment, "%%EOF"}, tNL, {ItemNumber, "1"}, tSpace, {ItemComment, "%%EOF"}, {ItemPostamble, "\n@"}, tEOF,
+		}},
+		{"no header", "1 0 obj", []lexed{{ItemNumber, "1"}, tSpace, {ItemNumber, "0"}, tSpace, {ItemObj, "obj"}, tEOF}},
+		{"header first", "%PDF-1.7", []lexed{{ItemComment, "%PDF-1.7"}, tEOF}},
+	}, WithSkipGarbage())
+
+	// without the option the junk is lexed, and usually fails
+	runLexTests(t, []lexTest{
+		{"off", "%%EOF\r\n@", []lexed{{ItemComment, "%%EOF"}, {ItemSpace, "\r\n"}, {ItemError, "illegal character: U+0040 '@'"}}},
+	})
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		val   string
+		real  bool
+		n     int64
+		intOK bool
+		f     float64
+	}{
+		{"5", false, 5, true, 5},
+		{"-5", false, -5, true, -5},
+		{"+0", false, 0, true, 0},
+		{"007", false, 7, true, 7},
+		{".5", true, 0, false, 0.5},
+		{"5.", true, 0, false, 5},
+		{"-.5", true, 0, false, -0.5},
+		{"9223372036854775807", false, 9223372036854775807, true, 9223372036854775807},
+		{"9223372036854775808", false, 0, false, 9223372036854775808},
+	}
+	for _, test := range tests {
+		it := Item{Typ: ItemNumber, Val: test.val}
+		if it.IsReal() != test.real {
+			t.Errorf("%s: IsReal = %v", test.val, it.IsReal())
+		}
+		if n, ok := it.Int(); n != test.n || ok != test.intOK {
+			t.Errorf("%s: Int = %d, %v, want %d, %v", test.val, n, ok, test.n, test.intOK)
+		}
+		if f, ok := it.Float(); f != test.f || !ok {
+			t.Errorf("%s: Float = %v, %v, want %v", test.val, f, ok, test.f)
+		}
+	}
+
+	name := Item{Typ: ItemName, Val: "/5"}
+	if _, ok := name.Int(); ok || name.IsReal() {
+		t.Error("a name is a number")
+	}
+	if _, ok := name.Float(); ok {
+		t.Error("a name is a float")
+	}
+}
+
+func TestContentLexer(t *testing.T) {
+	op := func(s string) lexed { return lexed{ItemOperator, s} }
+	tests := []lexTest{
+		{"text", "BT /F1 12 Tf (x) Tj T* ET", []lexed{
+			op("BT"), tSpace, {ItemName, "/F1"}, tSpace, {ItemNumber, "12"}, tSpace, op("Tf"), tSpace,
+			{ItemString, "(x)"}, tSpace, op("Tj"), tSpace, op("T*"), tSpace, op("ET"), tEOF,
+		}},
+		{"quotes", "(a) ' 1 2 (b) \"", []lexed{
+			{ItemString, "(a)"}, tSpace, op("'"), tSpace, {ItemNumber, "1"}, tSpace, {ItemNumber, "2"}, tSpace,
+			{ItemString, "(b)"}, tSpace, op("\""), tEOF,
+		}},
+		{"stars", "f* B* b* W*", []lexed{op("f*"), tSpace, op("B*"), tSpace, op("b*"), tSpace, op("W*"), tEOF}},
+		{"unknown star", "Q* a*b", []lexed{{ItemWord, "Q*"}, tSpace, {ItemWord, "a*b"}, tEOF}},
+		{"lone star", "*", []lexed{{ItemError, "illegal character: U+002A '*'"}}},
+
+		{"inline image", "BI /W 1 ID \x00EI\xff EI Q", []lexed{
+			op("BI"), tSpace, {ItemName, "/W"}, tSpace, {ItemNumber, "1"}, tSpace,
+			op("ID "), {ItemStreamBody, "\x00EI\xff"}, tSpace, op("EI"), tSpace, op("Q"), tEOF,
+		}},
+		{"EI in data", "ID xEI EI", []lexed{op("ID "), {ItemStreamBody, "xEI"}, tSpace, op("EI"), tEOF}},
+		{"EI prefix", "ID x EIx EI", []lexed{op("ID "), {ItemStreamBody, "x EIx"}, tSpace, op("EI"), tEOF}},
+		{"EI then delim", "ID x EI/X", []lexed{op("ID "), {ItemStreamBody, "x"}, tSpace, op("EI"), {ItemName, "/X"}, tEOF}},
+		{"EI at EOF", "ID\nx\nEI", []lexed{op("ID\n"), {ItemStreamBody, "x"}, tNL, op("EI"), tEOF}},
+		{"empty data", "ID \nEI", []lexed{op("ID "), {ItemStreamBody, ""}, tNL, op("EI"), tEOF}},
+		{"no EI", "ID abc", []lexed{op("ID "), {ItemError, "unterminated inline image"}}},
+		{"ID then delim", "ID(x)", []lexed{{ItemError, "ID operator not followed by whitespace"}}},
+		{"ID at EOF", "ID", []lexed{{ItemError, "ID operator not followed by whitespace"}}},
+		{"longer word", "IDx", []lexed{{ItemWord, "IDx"}, tEOF}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lexItems(NewContentLexer("test", test.input)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%q:\ngot  %v\nwant %v", test.input, got, test.want)
+			}
+		})
+	}
+
+	// none of this is special outside a content stream
+	runLexTests(t, []lexTest{
+		{"document", "T* ", []lexed{{ItemWord, "T"}, {ItemError, "illegal character: U+002A '*'"}}},
+		{"document ID", "ID abc", []lexed{{ItemWord, "ID"}, tSpace, {ItemWord, "abc"}, tEOF}},
+	})
+}
+
+func TestMaxTokenBytes(t *testing.T) {
+	const n = 8
+	tooLong := lexed{ItemError, "token exceeds limit of 8 bytes"}
+	a := func(k int) string { return strings.Repeat("a", k) }
+	runLexTests(t, []lexTest{
+		{"string at limit", "(" + a(n-2) + ")", []lexed{{ItemString, "(" + a(n-2) + ")"}, tEOF}},
+		{"string over", "(" + a(n-1) + ")", []lexed{tooLong}},
+		{"escape over", "(" + a(n-3) + "\\))", []lexed{tooLong}},
+		{"unterminated string", "(" + a(100), []lexed{tooLong}},
+		{"hex at limit", "<" + a(n-2) + ">", []lexed{{ItemHexString, "<" + a(n-2) + ">"}, tEOF}},
+		{"hex over", "<" + a(n-1) + ">", []lexed{tooLong}},
+		{"name at limit", "/" + a(n-1), []lexed{{ItemName, "/" + a(n-1)}, tEOF}},
+		{"name over", "/" + a(n), []lexed{tooLong}},
+		{"body at limit", "stream\n" + a(n) + "endstream", []lexed{
+			{ItemStream, "stream\n"}, {ItemStreamBody, a(n)}, {ItemEndStream, "endstream"}, tEOF,
+		}},
+		{"body over", "stream\n" + a(n+1) + "endstream", []lexed{{ItemStream, "stream\n"}, tooLong}},
+		{"unclosed body", "stream\n" + a(100), []lexed{{ItemStream, "stream\n"}, tooLong}},
+		{"short unclosed body", "stream\n" + a(n), []lexed{{ItemStream, "stream\n"}, {ItemError, "unclosed stream"}}},
+	}, WithMaxTokenBytes(n))
+
+	image := func(k int) []lexed {
+		return lexItems(NewContentLexer("test", "ID "+a(k)+" EI", WithMaxTokenBytes(n)))
+	}
+	if got, want := image(n), []lexed{{ItemOperator, "ID "}, {ItemStreamBody, a(n)}, tSpace, {ItemOperator, "EI"}, tEOF}; !reflect.DeepEqual(got, want) {
+		t.Errorf("image at limit: got %v, want %v", got, want)
+	}
+	if got, want := image(n+1), []lexed{{ItemOperator, "ID "}, tooLong}; !reflect.DeepEqual(got, want) {
+		t.Errorf("image over: got %v, want %v", got, want)
+	}
+
+	// no limit by default
+	if got := lexAll("(" + a(1<<16) + ")"); got[0].typ != ItemString {
+		t.Errorf("long string without a limit: %v", got[0].typ)
+	}
+}
+
+func TestWithKeywords(t *testing.T) {
+	const ItemCustom = ItemNull + 1
+	runLexTests(t, []lexTest{
+		{"add", "foo bar", []lexed{{ItemCustom, "foo"}, tSpace, {ItemWord, "bar"}, tEOF}},
+		{"override", "endobj", []lexed{{ItemKeyword, "endobj"}, tEOF}},
+		{"turn off", "trailer", []lexed{{ItemWord, "trailer"}, tEOF}},
+	}, WithKeywords(map[string]ItemType{"foo": ItemCustom, "endobj": ItemKeyword, "trailer": ItemWord}))
+
+	// turning off ID stops inline image data being skipped
+	l := NewContentLexer("test", "ID EI", WithKeywords(map[string]ItemType{"ID": ItemWord}))
+	if got, want := lexItems(l), []lexed{{ItemWord, "ID"}, tSpace, {ItemOperator, "EI"}, tEOF}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ID off: got %v, want %v", got, want)
+	}
+
+	// the options are per lexer, and the map isn't kept
+	kw := map[string]ItemType{"foo": ItemCustom}
+	l = NewLexer("test", "foo", WithKeywords(kw))
+	kw["foo"] = ItemWord
+	if got := lexItems(l); got[0].typ != ItemCustom {
+		t.Errorf("changing the map after NewLexer changed the lexer: %v", got)
+	}
+	if got := lexAll("foo trailer"); got[0].typ != ItemWord || got[2].typ != ItemTrailer {
+		t.Errorf("keywords leaked into another lexer: %v", got)
+	}
+}
@@ -0,0 +1,979 @@
+// (c) Ben Nagy 2015
+
+package pdflex
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Object is a PDF basic object as built by the Parser. The dynamic type is
+// one of nil (null), bool, int64, float64, Name, String, HexString, Ref,
+// Array, Dict or Stream, or Proc or Op in the code of Type 4 functions.
+// cf PDF3200_2008.pdf 7.3
+type Object interface{}
+
+// Name is a PDF Name object without the leading solidus. #XX escapes are left
+// as they appear in the input.
+type Name string
+
+// String is a PDF Literal String, exactly as it appears in the input
+// including the enclosing parens.
+type String string
+
+// HexString is a PDF Hex String, exactly as it appears in the input including
+// the enclosing angle brackets.
+type HexString string
+
+// Ref is an indirect reference such as `1 0 R`
+// cf PDF3200_2008.pdf 7.3.10
+type Ref struct {
+	Num int
+	Gen int
+}
+
+// Array is a PDF Array object.
+type Array []Object
+
+// Dict is a PDF Dictionary object.
+type Dict map[Name]Object
+
+// Proc is a brace delimited procedure from the code of a PostScript
+// calculator (Type 4) function.
+// cf PDF3200_2008.pdf 7.10.5
+type Proc []Object
+
+// Op is an operator in a Proc, like add or ifelse.
+type Op string
+
+// Stream is a PDF Stream object. Body holds the raw, undecoded contents.
+// cf PDF3200_2008.pdf 7.3.8
+type Stream struct {
+	Dict Dict
+	Body Item
+}
+
+// IndirectObject is a labelled object definition `N G obj ... endobj`
+// cf PDF3200_2008.pdf 7.3.10
+type IndirectObject struct {
+	Ref
+	Pos Pos // position of the object number
+	Obj Object
+}
+
+// Parser builds PDF objects from the items produced by a Lexer. It does not
+// try to understand the document, it just knows how to assemble tokens into
+// basic objects.
+type Parser struct {
+	Strict       bool         // make tolerable problems errors, not Diagnostics
+	CheckLength  bool         // compare stream /Length with the actual body
+	StrictBraces bool         // make procedures outside Type 4 functions errors
+	Diagnostics  []Diagnostic // tolerated problems, in the order they were found
+	name         string
+	lex          *Lexer
+	items        []Item // items that have been backed up, most recent last
+	function     bool   // parsing Type 4 function code, see ParseFunction
+	depth        int    // arrays, dicts and procedures open in the object
+}
+
+// maxParseDepth is the deepest the Parser will nest arrays, dicts and
+// procedures. It recurses for each level, and a stack overflow can't be
+// recovered from, so adversarial input mustn't be able to go deeper.
+const maxParseDepth = 1000
+
+// Diagnostic is a problem that the Parser tolerated, either because it is
+// harmless or because some readers accept it. These are often interesting for
+// security analysis, since different readers may disagree about the result.
+type Diagnostic struct {
+	Pos Pos
+	Msg string
+}
+
+// NewParser creates a Parser reading items from l.
+func NewParser(l *Lexer) *Parser {
+	return &Parser{
+		name: l.name,
+		lex:  l,
+	}
+}
+
+// next returns the next item, including whitespace and comments. Values
+// left out by WithLazyValues are filled in, since the parser needs them all.
+func (p *Parser) next() Item {
+	if n := len(p.items); n > 0 {
+		it := p.items[n-1]
+		p.items = p.items[:n-1]
+		return it
+	}
+	it := p.lex.NextItem()
+	if it.lazy() {
+		it.Val = p.lex.Value(it)
+	}
+	return it
+}
+
+// backup pushes an item back to be returned by the next call to next.
+func (p *Parser) backup(it Item) {
+	p.items = append(p.items, it)
+}
+
+// NextItem returns the next item, including whitespace and comments, for
+// callers that walk the items themselves but want the Parser's rules for
+// parts of the syntax, such as Ref.
+func (p *Parser) NextItem() Item {
+	return p.next()
+}
+
+// Backup pushes an item back to be returned by the next call to NextItem.
+func (p *Parser) Backup(it Item) {
+	p.backup(it)
+}
+
+// nextNonSpace returns the next item that is not whitespace or a comment.
+func (p *Parser) nextNonSpace() Item {
+	for {
+		it := p.next()
+		if it.Typ != ItemSpace && it.Typ != ItemComment {
+			return it
+		}
+	}
+}
+
+// errorf formats an error for a problem found at pos.
+func (p *Parser) errorf(pos Pos, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, pos, fmt.Sprintf(format, args...))
+}
+
+// diagnosef records a tolerated problem found at pos. In strict mode it
+// returns the problem as an error instead.
+func (p *Parser) diagnosef(pos Pos, format string, args ...interface{}) error {
+	if p.Strict {
+		return p.errorf(pos, format, args...)
+	}
+	p.Diagnostics = append(p.Diagnostics, Diagnostic{pos, fmt.Sprintf(format, args...)})
+	return nil
+}
+
+// unexpected reports it as out of place while parsing context. Lexer errors
+// are passed through as they are.
+func (p *Parser) unexpected(it Item, context string) error {
+	switch it.Typ {
+	case ItemError:
+		return p.errorf(it.Pos, "%s", it.Val)
+	case ItemEOF:
+		return p.errorf(it.Pos, "unexpected EOF in %s", context)
+	}
+	return p.errorf(it.Pos, "unexpected %q in %s", it.Val, context)
+}
+
+// ParseObject parses the next object from the input.
+func (p *Parser) ParseObject() (Object, error) {
+	return p.object(p.nextNonSpace())
+}
+
+// object parses the object starting with it.
+func (p *Parser) object(it Item) (Object, error) {
+	switch it.Typ {
+	case ItemLeftArray, ItemLeftDict, ItemLeftBrace:
+		if p.depth >= maxParseDepth {
+			return nil, p.errorf(it.Pos, "nesting exceeds maximum depth %d", maxParseDepth)
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+	}
+	switch it.Typ {
+	case ItemNumber:
+		return p.number(it)
+	case ItemName:
+		return Name(it.Val[1:]), nil
+	case ItemString:
+		return String(it.Val), nil
+	case ItemHexString:
+		return HexString(it.Val), nil
+	case ItemTrue:
+		return true, nil
+	case ItemFalse:
+		return false, nil
+	case ItemNull:
+		return nil, nil
+	case ItemLeftArray:
+		return p.array()
+	case ItemLeftDict:
+		return p.dict()
+	case ItemLeftBrace:
+		if !p.function {
+			if p.StrictBraces {
+				return nil, p.errorf(it.Pos, "procedure outside a Type 4 function")
+			}
+			if err := p.diagnosef(it.Pos, "procedure outside a Type 4 function"); err != nil {
+				return nil, err
+			}
+		}
+		return p.proc()
+	}
+	return nil, p.unexpected(it, "object")
+}
+
+// proc parses the contents of a procedure. The opening '{' has already been
+// consumed. Any word is taken to be an operator.
+func (p *Parser) proc() (Object, error) {
+	pr := Proc{}
+	for {
+		it := p.nextNonSpace()
+		switch it.Typ {
+		case ItemRightBrace:
+			return pr, nil
+		case ItemWord:
+			pr = append(pr, Op(it.Val))
+		default:
+			o, err := p.object(it)
+			if err != nil {
+				return nil, err
+			}
+			pr = append(pr, o)
+		}
+	}
+}
+
+// ParseFunction parses the code of a PostScript calculator function, given
+// its dictionary and the already decoded stream body. The code is a single
+// procedure, and braces are always allowed in it, whatever StrictBraces says.
+// cf PDF3200_2008.pdf 7.10.5
+func ParseFunction(name string, d Dict, code string) (Proc, error) {
+	if d["FunctionType"] != int64(4) {
+		return nil, fmt.Errorf("%s: not a Type 4 function", name)
+	}
+	p := NewParser(NewLexer(name, code))
+	defer p.lex.Drain()
+	p.function = true
+
+	it := p.nextNonSpace()
+	if it.Typ != ItemLeftBrace {
+		return nil, p.unexpected(it, "function")
+	}
+	o, err := p.proc()
+	if err != nil {
+		return nil, err
+	}
+	if it := p.nextNonSpace(); it.Typ != ItemEOF {
+		return nil, p.unexpected(it, "function")
+	}
+	return o.(Proc), nil
+}
+
+// number parses an integer or real number. Integers might be the start of an
+// indirect reference, in which case a Ref is returned instead.
+func (p *Parser) number(it Item) (Object, error) {
+	n, ok := it.Int()
+	if !ok {
+		f, ok := it.Float()
+		if !ok {
+			return nil, p.errorf(it.Pos, "bad number syntax: %q", it.Val)
+		}
+		return f, nil
+	}
+	if ref, ok := p.ref(it); ok {
+		return ref, nil
+	}
+	return n, nil
+}
+
+// ref tries to complete an indirect reference `N G R` given the item holding
+// N. Detection is strictly positional: N and G must be unsigned integers and
+// the three parts must be separated by whitespace alone, so that a stray R,
+// or an R after anything else, is left alone. If the following items don't
+// fit, they are backed up and ok is false.
+func (p *Parser) ref(num Item) (ref Ref, ok bool) {
+	if !isUint(num) {
+		return
+	}
+	var read []Item
+	defer func() {
+		if !ok {
+			for i := len(read) - 1; i >= 0; i-- {
+				p.backup(read[i])
+			}
+		}
+	}()
+	next := func() Item {
+		it := p.next()
+		read = append(read, it)
+		return it
+	}
+
+	if next().Typ != ItemSpace {
+		return
+	}
+	gen := next()
+	if !isUint(gen) || next().Typ != ItemSpace {
+		return
+	}
+	if r := next(); r.Typ != ItemWord || r.Val != "R" {
+		return
+	}
+	n, ok1 := num.Int()
+	g, ok2 := gen.Int()
+	if !ok1 || !ok2 {
+		return
+	}
+	return Ref{int(n), int(g)}, true
+}
+
+// Ref reports whether num, an item just returned by NextItem, starts an
+// indirect reference N G R, by the same positional rule ParseObject uses. If
+// it does, the rest of the reference is consumed, otherwise nothing more is.
+func (p *Parser) Ref(num Item) (ref Ref, ok bool) {
+	return p.ref(num)
+}
+
+// isUint reports whether it is a number made only of digits.
+func isUint(it Item) bool {
+	return it.Typ == ItemNumber && strings.Trim(it.Val, "0123456789") == ""
+}
+
+// array parses the contents of an array. The opening '[' has already been
+// consumed.
+func (p *Parser) array() (Object, error) {
+	a := Array{}
+	for {
+		it := p.nextNonSpace()
+		if it.Typ == ItemRightArray {
+			return a, nil
+		}
+		o, err := p.object(it)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, o)
+	}
+}
+
+// dict parses the contents of a dictionary. The opening '<<' has already been
+// consumed. Duplicate keys are invalid, but unless the parser is strict they
+// are recorded as a Diagnostic and the last value wins.
+func (p *Parser) dict() (Object, error) {
+	d := Dict{}
+	seen := map[Name]Pos{}
+	for {
+		key := p.nextNonSpace()
+		switch key.Typ {
+		case ItemRightDict:
+			return d, nil
+		case ItemName:
+		default:
+			return nil, p.unexpected(key, "dict key")
+		}
+		it := p.nextNonSpace()
+		if it.Typ == ItemRightDict {
+			return nil, p.errorf(it.Pos, "missing value for dict key %s", key.Val)
+		}
+		o, err := p.object(it)
+		if err != nil {
+			return nil, err
+		}
+		k := Name(key.Val[1:])
+		if first, dup := seen[k]; dup {
+			err := p.diagnosef(key.Pos, "duplicate dict key %s, first seen at %d", key.Val, first)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			seen[k] = key.Pos
+		}
+		d[k] = o
+	}
+}
+
+// ParseIndirect parses an indirect object definition, including the stream
+// contents if it is a stream object.
+func (p *Parser) ParseIndirect() (*IndirectObject, error) {
+	num := p.nextNonSpace()
+	if num.Typ != ItemNumber {
+		return nil, p.unexpected(num, "object header")
+	}
+	gen := p.nextNonSpace()
+	if gen.Typ != ItemNumber {
+		return nil, p.unexpected(gen, "object header")
+	}
+	if kw := p.nextNonSpace(); kw.Typ != ItemObj {
+		return nil, p.unexpected(kw, "object header")
+	}
+	n, ok1 := num.Int()
+	g, ok2 := gen.Int()
+	if !ok1 || !ok2 {
+		return nil, p.errorf(num.Pos, "bad object header %s %s obj", num.Val, gen.Val)
+	}
+
+	o, err := p.ParseObject()
+	if err != nil {
+		return nil, err
+	}
+	it := p.nextNonSpace()
+	if it.Typ == ItemStream {
+		d, ok := o.(Dict)
+		if !ok {
+			return nil, p.errorf(it.Pos, "stream without a dictionary")
+		}
+		body := p.next()
+		if body.Typ != ItemStreamBody {
+			return nil, p.unexpected(body, "stream")
+		}
+		if end := p.next(); end.Typ != ItemEndStream {
+			return nil, p.unexpected(end, "stream")
+		}
+		if p.CheckLength {
+			if err := p.checkLength(d, body); err != nil {
+				return nil, err
+			}
+		}
+		o = Stream{d, body}
+		it = p.nextNonSpace()
+	}
+	if it.Typ != ItemEndObj {
+		return nil, p.unexpected(it, "object")
+	}
+	return &IndirectObject{Ref{int(n), int(g)}, num.Pos, o}, nil
+}
+
+// checkLength compares the /Length declared in a stream dictionary with the
+// body that the lexer found by scanning for endstream. The body may end with
+// an EOL that isn't counted in /Length. A mismatch is a common sign of
+// corruption or tampering. An indirect /Length can't be resolved here, so
+// it is just noted.
+// cf PDF3200_2008.pdf 7.3.8.2
+func (p *Parser) checkLength(d Dict, body Item) error {
+	actual := int64(len(body.Val))
+	switch n := d["Length"].(type) {
+	case int64:
+		eol := int64(0)
+		switch {
+		case strings.HasSuffix(body.Val, "\r\n"):
+			eol = 2
+		case strings.HasSuffix(body.Val, "\n") || strings.HasSuffix(body.Val, "\r"):
+			eol = 1
+		}
+		if n == actual || n == actual-eol {
+			return nil
+		}
+		return p.diagnosef(body.Pos, "stream /Length is %d but the body is %d bytes", n, actual)
+	case Ref:
+		p.Diagnostics = append(p.Diagnostics, Diagnostic{
+			body.Pos,
+			fmt.Sprintf("stream /Length is indirect (%d %d R), body is %d bytes", n.Num, n.Gen, actual),
+		})
+		return nil
+	case nil:
+		return p.diagnosef(body.Pos, "stream has no /Length, body is %d bytes", actual)
+	}
+	return p.diagnosef(body.Pos, "stream /Length is not an integer")
+}
+
+// CheckObjects lexes input and checks that every indirect object is closed.
+// An object whose endobj is missing, so that the next object header or the
+// end of input comes first, gets a Diagnostic giving its number and
+// position. So does a stream body that contains an object header on a line of
+// its own. That usually means the endstream is missing and the lexer found
+// a later one, so the body has swallowed the objects that follow.
+// cf PDF3200_2008.pdf 7.3.10, 7.3.8.1
+func CheckObjects(name, input string) ([]Diagnostic, error) {
+	p := NewParser(NewLexer(name, input))
+	defer p.lex.Drain()
+
+	var (
+		prev [2]Item // the two significant items before this one
+		open *Ref    // the object being defined, if any
+		at   Pos     // position of its header
+	)
+	for {
+		it := p.nextNonSpace()
+		switch it.Typ {
+		case ItemError:
+			return nil, p.errorf(it.Pos, "%s", it.Val)
+		case ItemEOF:
+			if open != nil {
+				if err := p.diagnosef(at, "object %d %d not closed before end of input", open.Num, open.Gen); err != nil {
+					return nil, err
+				}
+			}
+			return p.Diagnostics, nil
+		case ItemObj:
+			num, gen := prev[0], prev[1]
+			if !isUint(num) || !isUint(gen) {
+				if err := p.diagnosef(it.Pos, "obj without an object number"); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if open != nil {
+				if err := p.diagnosef(at, "object %d %d not closed before object %s %s at %d", open.Num, open.Gen, num.Val, gen.Val, num.Pos); err != nil {
+					return nil, err
+				}
+			}
+			n, _ := num.Int()
+			g, _ := gen.Int()
+			open, at = &Ref{int(n), int(g)}, num.Pos
+		case ItemEndObj:
+			if open == nil {
+				if err := p.diagnosef(it.Pos, "endobj outside an object"); err != nil {
+					return nil, err
+				}
+			}
+			open = nil
+		case ItemStreamBody:
+			if off := objHeaderIn(it.Val); off >= 0 {
+				if err := p.diagnosef(it.Pos+off, "stream body runs into an object header, endstream may be missing"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		prev[0], prev[1] = prev[1], it
+	}
+}
+
+// objHeaderIn returns the offset in s of the first object header that starts
+// a line, or -1.
+func objHeaderIn(s string) Pos {
+	for i := 0; i < len(s); i++ {
+		if isEndOfLine(rune(s[i])) && isObjHeader(s[i+1:]) {
+			return Pos(i + 1)
+		}
+	}
+	return -1
+}
+
+// Trailer holds the interesting entries from a trailer dictionary.
+// cf PDF3200_2008.pdf 7.5.5
+type Trailer struct {
+	Pos     Pos   // position of the trailer keyword
+	Dict    Dict  // the complete trailer dictionary
+	Root    Ref   // the document catalog
+	Size    int64 // number of entries in the cross-reference table
+	Prev    int64 // offset of the previous cross-reference section, or -1
+	Encrypt *Ref  // nil unless /Encrypt is an indirect reference
+}
+
+// Trailers lexes input and returns every trailer in it, in file order.
+// Incrementally updated documents have one trailer per update.
+func Trailers(name, input string) ([]Trailer, error) {
+	p := NewParser(NewLexer(name, input))
+	defer p.lex.Drain()
+
+	var trailers []Trailer
+	for {
+		it := p.next()
+		switch it.Typ {
+		case ItemEOF:
+			return trailers, nil
+		case ItemError:
+			return nil, p.errorf(it.Pos, "%s", it.Val)
+		case ItemTrailer:
+			t, err := p.trailer(it)
+			if err != nil {
+				return nil, err
+			}
+			trailers = append(trailers, t)
+		}
+	}
+}
+
+// trailer parses the dictionary following the trailer keyword kw.
+func (p *Parser) trailer(kw Item) (Trailer, error) {
+	t := Trailer{Pos: kw.Pos, Prev: -1}
+	it := p.nextNonSpace()
+	if it.Typ != ItemLeftDict {
+		return t, p.unexpected(it, "trailer")
+	}
+	o, err := p.dict()
+	if err != nil {
+		return t, err
+	}
+	t.Dict = o.(Dict)
+
+	var ok bool
+	if v, found := t.Dict["Root"]; found {
+		if t.Root, ok = v.(Ref); !ok {
+			return t, p.errorf(kw.Pos, "trailer /Root is not an indirect reference")
+		}
+	}
+	if v, found := t.Dict["Size"]; found {
+		if t.Size, ok = v.(int64); !ok {
+			return t, p.errorf(kw.Pos, "trailer /Size is not an integer")
+		}
+	}
+	if v, found := t.Dict["Prev"]; found {
+		if t.Prev, ok = v.(int64); !ok {
+			return t, p.errorf(kw.Pos, "trailer /Prev is not an integer")
+		}
+	}
+	if ref, ok := t.Dict["Encrypt"].(Ref); ok {
+		t.Encrypt = &ref
+	}
+	return t, nil
+}
+
+// XRefKind distinguishes classic cross-reference tables from the
+// cross-reference streams introduced in PDF 1.5.
+type XRefKind int
+
+const (
+	XRefUnknown XRefKind = iota // nothing recognisable at the offset
+	XRefTable                   // classic `xref` section 7.5.4
+	XRefStream                  // stream object with /Type /XRef 7.5.8
+)
+
+// XRef describes the cross-reference section found at a startxref offset.
+type XRef struct {
+	Offset Pos
+	Kind   XRefKind
+	Obj    *IndirectObject // the stream object, when Kind is XRefStream
+}
+
+// FindXRef finds the last startxref in input and reports what kind of
+// cross-reference section its offset points at. For an XRefStream the xref
+// table is compressed inside the stream body, so there will be no xref or
+// trailer keywords in the token stream.
+func FindXRef(name, input string) (*XRef, error) {
+	p := NewParser(NewLexer(name, input))
+	defer p.lex.Drain()
+
+	var (
+		offset Pos
+		found  bool
+	)
+	for {
+		it := p.next()
+		switch it.Typ {
+		case ItemError:
+			return nil, p.errorf(it.Pos, "%s", it.Val)
+		case ItemStartXref:
+			o, err := p.startXRef()
+			if err != nil {
+				return nil, err
+			}
+			offset, found = o, true
+		}
+		if it.Typ == ItemEOF {
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: no startxref found", name)
+	}
+	return XRefAt(name, input, offset)
+}
+
+// StartXRef is a startxref keyword and the offset that follows it.
+// cf PDF3200_2008.pdf 7.5.5
+type StartXRef struct {
+	Pos    Pos // position of the startxref keyword
+	Offset Pos // byte offset of the last cross-reference section, as written
+}
+
+// StartXRefs lexes input and returns every startxref in it, in file order.
+// Offsets that are out of range, or that don't land on an xref keyword or an
+// object header, are common symptoms of corruption. They are returned anyway,
+// for recovery tools to search near, along with a Diagnostic for each.
+func StartXRefs(name, input string) ([]StartXRef, []Diagnostic, error) {
+	p := NewParser(NewLexer(name, input))
+	defer p.lex.Drain()
+
+	var sxs []StartXRef
+	for {
+		it := p.next()
+		switch it.Typ {
+		case ItemEOF:
+			return sxs, p.Diagnostics, nil
+		case ItemError:
+			return nil, nil, p.errorf(it.Pos, "%s", it.Val)
+		case ItemStartXref:
+			o, err := p.startXRef()
+			if err != nil {
+				return nil, nil, err
+			}
+			sxs = append(sxs, StartXRef{it.Pos, o})
+		}
+	}
+}
+
+// Revision is one section of an incrementally updated document, ending with
+// startxref, the offset, and %%EOF. The first revision is the original
+// document and each later one is an update appended to it.
+// cf PDF3200_2008.pdf 7.5.6
+type Revision struct {
+	Trailer   *Trailer // nil if the revision uses a cross-reference stream
+	StartXRef StartXRef
+	EOF       Pos // position of the %%EOF marker
+}
+
+// Revisions lexes input and returns its revisions in file order, each with
+// its trailer and startxref offset. A final revision that has a startxref but
+// no %%EOF is returned with a Diagnostic and EOF set to the end of input.
+// Trailers and startxref offsets are diagnosed as in Trailers and StartXRefs.
+func Revisions(name, input string) ([]Revision, []Diagnostic, error) {
+	p := NewParser(NewLexer(name, input))
+	defer p.lex.Drain()
+
+	var (
+		revs []Revision
+		cur  Revision
+		open bool // seen startxref, waiting for %%EOF
+	)
+	for {
+		it := p.next()
+		switch it.Typ {
+		case ItemEOF:
+			if open {
+				p.diagnosef(it.Pos, "no %s after last startxref", eofMarker)
+				cur.EOF = it.Pos
+				revs = append(revs, cur)
+			}
+			return revs, p.Diagnostics, nil
+		case ItemError:
+			return nil, nil, p.errorf(it.Pos, "%s", it.Val)
+		case ItemTrailer:
+			t, err := p.trailer(it)
+			if err != nil {
+				return nil, nil, err
+			}
+			cur.Trailer = &t
+		case ItemStartXref:
+			o, err := p.startXRef()
+			if err != nil {
+				return nil, nil, err
+			}
+			cur.StartXRef = StartXRef{it.Pos, o}
+			open = true
+		case ItemComment:
+			if open && strings.HasPrefix(it.Val, eofMarker) {
+				cur.EOF = it.Pos
+				revs = append(revs, cur)
+				cur, open = Revision{}, false
+			}
+		}
+	}
+}
+
+// startXRef parses the offset following a startxref keyword, which has just
+// been read. Implausible offsets are diagnosed, but still returned.
+func (p *Parser) startXRef() (Pos, error) {
+	n := p.nextNonSpace()
+	if n.Typ != ItemNumber {
+		return 0, p.unexpected(n, "startxref")
+	}
+	o, ok := n.Int()
+	if !ok {
+		return 0, p.errorf(n.Pos, "bad startxref offset %q", n.Val)
+	}
+	input := p.lex.input
+	switch {
+	case o < 0 || o >= int64(len(input)):
+		return Pos(o), p.diagnosef(n.Pos, "startxref offset %d out of range", o)
+	case !strings.HasPrefix(input[o:], "xref") && !isObjHeader(input[o:]):
+		return Pos(o), p.diagnosef(n.Pos, "startxref offset %d is not at xref or an object", o)
+	}
+	return Pos(o), nil
+}
+
+// isObjHeader reports whether s starts with an indirect object header, like
+// "12 0 obj".
+func isObjHeader(s string) bool {
+	for i := 0; i < 2; i++ {
+		n := 0
+		for n < len(s) && '0' <= s[n] && s[n] <= '9' {
+			n++
+		}
+		if n == 0 {
+			return false
+		}
+		s = s[n:]
+		n = 0
+		for n < len(s) && isPDFWhitespace(rune(s[n])) {
+			n++
+		}
+		if n == 0 {
+			return false
+		}
+		s = s[n:]
+	}
+	return strings.HasPrefix(s, "obj")
+}
+
+// XRefAt reports what kind of cross-reference section, if any, starts at
+// offset.
+func XRefAt(name, input string, offset Pos) (*XRef, error) {
+	if offset < 0 || int(offset) >= len(input) {
+		return nil, fmt.Errorf("%s: xref offset %d out of range", name, offset)
+	}
+	x := &XRef{Offset: offset}
+	if strings.HasPrefix(input[offset:], "xref") {
+		x.Kind = XRefTable
+		return x, nil
+	}
+
+	l := NewLexer(name, input)
+	if err := l.SeekTo(offset); err != nil {
+		return nil, err
+	}
+	p := NewParser(l)
+	defer p.lex.Drain()
+	obj, err := p.ParseIndirect()
+	if err != nil {
+		return x, nil
+	}
+	if s, ok := obj.Obj.(Stream); ok && s.Dict["Type"] == Name("XRef") {
+		x.Kind = XRefStream
+		x.Obj = obj
+	}
+	return x, nil
+}
+
+// ObjectBytes returns the raw text of the last definition of object objNum
+// gen in input, from the start of its "N G obj" header to the end of its
+// endobj, including any stream. An incremental update that redefines an
+// object appends the new definition, so the last one is current. ok is false
+// if the object isn't defined.
+func ObjectBytes(input string, objNum, gen int) (raw string, ok bool) {
+	defs := ObjectDefinitions(input, objNum, gen)
+	if len(defs) == 0 {
+		return "", false
+	}
+	return defs[len(defs)-1], true
+}
+
+// ObjectDefinitions is like ObjectBytes, but returns every definition of the
+// object, in file order. Definitions with no endobj before the next object
+// header are left out, since their extent is unknown. Lexing stops at the
+// first error, so only definitions before that are found.
+func ObjectDefinitions(input string, objNum, gen int) []string {
+	var (
+		defs  []string
+		prev  [2]Item // the two items before this one
+		start Pos     = -1
+	)
+	LexFunc("", input, func(it Item) bool {
+		switch it.Typ {
+		case ItemObj:
+			start = -1
+			if isUint(prev[0]) && isUint(prev[1]) {
+				n, _ := prev[0].Int()
+				g, _ := prev[1].Int()
+				if n == int64(objNum) && g == int64(gen) {
+					start = prev[0].Pos
+				}
+			}
+		case ItemEndObj:
+			if start >= 0 {
+				defs = append(defs, input[start:it.End()])
+				start = -1
+			}
+		}
+		prev[0], prev[1] = prev[1], it
+		return true
+	}, WithSkipTrivia())
+	return defs
+}
+
+// Linearized holds the linearization parameters from the first object of a
+// linearized ("fast web view") document. Integer entries that are missing or
+// not integers are zero.
+// cf PDF3200_2008.pdf Annex F.2.2
+type Linearized struct {
+	Obj *IndirectObject // the object holding the parameter dictionary
+	L   int64           // length of the file, in bytes
+	H   Array           // offsets and lengths of the primary hint stream
+	O   int64           // object number of the first page's page object
+	E   int64           // offset of the end of the first page
+	N   int64           // number of pages
+	T   int64           // offset of the first entry in the main xref table
+}
+
+// FindLinearized parses the first object in input and returns its
+// linearization parameters, or nil if it has no /Linearized entry and so the
+// document is not linearized. A declared /L that doesn't match the length of
+// input is recorded as a Diagnostic, since it usually means the file was
+// updated or truncated after linearization, and readers will ignore the
+// hints.
+func FindLinearized(name, input string) (*Linearized, []Diagnostic, error) {
+	p := NewParser(NewLexer(name, input))
+	defer p.lex.Drain()
+
+	obj, err := p.ParseIndirect()
+	if err != nil {
+		return nil, nil, err
+	}
+	d, ok := obj.Obj.(Dict)
+	if !ok {
+		return nil, nil, nil
+	}
+	if _, ok := d["Linearized"]; !ok {
+		return nil, nil, nil
+	}
+	lin := &Linearized{Obj: obj}
+	lin.L, _ = d["L"].(int64)
+	lin.H, _ = d["H"].(Array)
+	lin.O, _ = d["O"].(int64)
+	lin.E, _ = d["E"].(int64)
+	lin.N, _ = d["N"].(int64)
+	lin.T, _ = d["T"].(int64)
+	if lin.L != int64(len(input)) {
+		if err := p.diagnosef(obj.Pos, "linearized /L %d doesn't match file length %d", lin.L, len(input)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return lin, p.Diagnostics, nil
+}
+
+// ObjectStream parses the objects packed into an object stream, given the
+// stream dictionary and the already decoded body. The body starts with /N
+// pairs of integers giving the object number and the offset of each object
+// relative to /First. Positions in the results are relative to the body.
+// cf PDF3200_2008.pdf 7.5.7
+func ObjectStream(name string, d Dict, body string) ([]IndirectObject, error) {
+	if d["Type"] != Name("ObjStm") {
+		return nil, fmt.Errorf("%s: not an object stream", name)
+	}
+	n, ok1 := d["N"].(int64)
+	first, ok2 := d["First"].(int64)
+	if !ok1 || !ok2 || n < 0 || first < 0 || first > int64(len(body)) {
+		return nil, fmt.Errorf("%s: bad object stream /N or /First", name)
+	}
+
+	// /N isn't trusted to size anything. A header too short for it runs out
+	// of items instead.
+	p := NewParser(NewLexer(name, body[:first]))
+	defer p.lex.Drain()
+	var objs []IndirectObject
+	for i := int64(0); i < n; i++ {
+		num := p.nextNonSpace()
+		off := p.nextNonSpace()
+		if num.Typ != ItemNumber {
+			return nil, p.unexpected(num, "object stream header")
+		}
+		if off.Typ != ItemNumber {
+			return nil, p.unexpected(off, "object stream header")
+		}
+		o, ok1 := num.Int()
+		pos, ok2 := off.Int()
+		if !ok1 || !ok2 || pos < 0 || first+pos >= int64(len(body)) {
+			return nil, p.errorf(num.Pos, "bad object stream entry %s %s", num.Val, off.Val)
+		}
+		objs = append(objs, IndirectObject{Ref: Ref{int(o), 0}, Pos: Pos(first + pos)})
+	}
+
+	// objects inside object streams are bare, with no obj / endobj around
+	// them, and always have generation 0.
+	for i := range objs {
+		l := NewLexer(name, body)
+		if err := l.SeekTo(objs[i].Pos); err != nil {
+			return nil, err
+		}
+		sub := NewParser(l)
+		o, err := sub.ParseObject()
+		sub.lex.Drain()
+		if err != nil {
+			return nil, err
+		}
+		objs[i].Obj = o
+	}
+	return objs, nil
+}
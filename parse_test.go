@@ -0,0 +1,493 @@
+// (c) Ben Nagy 2015
+
+package pdflex
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withStartXRef appends a startxref pointing at the first occurrence of at in
+// doc, and the %%EOF marker.
+func withStartXRef(doc, at string) string {
+	return doc + "startxref\n" + strconv.Itoa(strings.Index(doc, at)) + "\n%%EOF\n"
+}
+
+func TestFindXRefStream(t *testing.T) {
+	doc := withStartXRef("%PDF-1.5\n"+
+		"1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n"+
+		"3 0 obj\n<< /Type /XRef /Size 4 /W [1 2 1] /Root 1 0 R /Length 4 >>\nstream\n\x01\x00\x09\x00\nendstream\nendobj\n",
+		"3 0 obj")
+
+	x, err := FindXRef("test", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Kind != XRefStream {
+		t.Fatalf("Kind = %v, want XRefStream", x.Kind)
+	}
+	if x.Obj == nil || x.Obj.Num != 3 {
+		t.Fatalf("Obj = %+v, want object 3", x.Obj)
+	}
+	if s := x.Obj.Obj.(Stream); s.Body.Val != "\x01\x00\x09\x00\n" {
+		t.Errorf("Body = %q", s.Body.Val)
+	}
+}
+
+func TestFindXRefTable(t *testing.T) {
+	doc := withStartXRef("%PDF-1.4\n"+
+		"1 0 obj\n<< /Type /Catalog >>\nendobj\n"+
+		"xref\n0 2\n0000000000 65535 f \n0000000009 00000 n \n"+
+		"trailer\n<< /Size 2 /Root 1 0 R >>\n",
+		"xref")
+
+	x, err := FindXRef("test", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Kind != XRefTable || x.Obj != nil {
+		t.Errorf("got %+v, want a table", x)
+	}
+}
+
+func TestRefDetection(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Object // nil means a parse error is expected
+	}{
+		{"ref", "[1 0 R]", Array{Ref{1, 0}}},
+		{"ref over lines", "[1  0\nR]", Array{Ref{1, 0}}},
+		{"two refs", "[1 0 R 2 0 R]", Array{Ref{1, 0}, Ref{2, 0}}},
+		{"plain ints", "[1 2 3]", Array{int64(1), int64(2), int64(3)}},
+		{"bare R", "[R]", nil},
+		{"R after name", "[/A R]", nil},
+		{"R after real", "[1.5 0 R]", nil},
+		{"real gen", "[1 0.0 R]", nil},
+		{"signed num", "[-1 0 R]", nil},
+		{"comment between", "[1 0 %c\n R]", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewParser(NewLexer("test", test.input)).ParseObject()
+			if test.want == nil {
+				if err == nil {
+					t.Errorf("%q: got %#v, want an error", test.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q: %v", test.input, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%q: got %#v, want %#v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	rev1 := withStartXRef("%PDF-1.4\n"+
+		"1 0 obj\n<< /Type /Catalog >>\nendobj\n"+
+		"xref\n0 2\n0000000000 65535 f \n0000000009 00000 n \n"+
+		"trailer\n<< /Size 2 /Root 1 0 R >>\n",
+		"xref")
+	doc := rev1 + "1 0 obj\n<< /Type /Catalog /Lang (en) >>\nendobj\n" +
+		"xref\n1 1\n" + "0000000000 00000 n \n" +
+		"trailer\n<< /Size 2 /Root 1 0 R /Prev " + strconv.Itoa(strings.Index(rev1, "xref")) + " >>\n"
+	doc = withStartXRef(doc, "xref\n1 1")
+
+	revs, diags, err := Revisions("test", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) > 0 {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revs))
+	}
+	xrefs := []int{strings.Index(doc, "xref"), strings.Index(doc, "xref\n1 1")}
+	prevs := []int64{-1, int64(xrefs[0])}
+	for i, r := range revs {
+		if r.Trailer == nil {
+			t.Fatalf("revision %d has no trailer", i)
+		}
+		if r.Trailer.Prev != prevs[i] {
+			t.Errorf("revision %d: Prev = %d, want %d", i, r.Trailer.Prev, prevs[i])
+		}
+		if int(r.StartXRef.Offset) != xrefs[i] {
+			t.Errorf("revision %d: startxref offset = %d, want %d", i, r.StartXRef.Offset, xrefs[i])
+		}
+		if !strings.HasPrefix(doc[r.EOF:], "%%EOF") {
+			t.Errorf("revision %d: EOF = %d, not at a %%%%EOF marker", i, r.EOF)
+		}
+	}
+	if revs[0].EOF >= revs[1].StartXRef.Pos {
+		t.Errorf("revisions out of order: %+v", revs)
+	}
+}
+
+func TestBraces(t *testing.T) {
+	fn := Dict{"FunctionType": int64(4), "Domain": Array{int64(0), int64(1)}}
+	code := "{ dup 0.5 gt { pop 1 } { 2 mul } ifelse }"
+	want := Proc{Op("dup"), 0.5, Op("gt"), Proc{Op("pop"), int64(1)}, Proc{int64(2), Op("mul")}, Op("ifelse")}
+
+	// inside a function, braces are always allowed
+	got, err := ParseFunction("test", fn, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseFunction got %#v, want %#v", got, want)
+	}
+	if _, err := ParseFunction("test", Dict{"FunctionType": int64(2)}, code); err == nil {
+		t.Error("ParseFunction accepted a Type 2 function")
+	}
+
+	// outside one, they are a Diagnostic by default and an error with
+	// StrictBraces
+	in := "<< /A { 1 } >>"
+	p := NewParser(NewLexer("test", in))
+	o, err := p.ParseObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o, Dict{"A": Proc{int64(1)}}) {
+		t.Errorf("got %#v", o)
+	}
+	if len(p.Diagnostics) != 1 || p.Diagnostics[0].Pos != 6 {
+		t.Errorf("Diagnostics = %v, want one at 6", p.Diagnostics)
+	}
+	p = NewParser(NewLexer("test", in))
+	p.StrictBraces = true
+	if _, err := p.ParseObject(); err == nil {
+		t.Error("StrictBraces allowed a procedure outside a function")
+	}
+
+	// the lexer tracks brace depth whatever the context
+	runLexTests(t, []lexTest{
+		{"balanced", "{{}}", []lexed{{ItemLeftBrace, "{"}, {ItemLeftBrace, "{"}, {ItemRightBrace, "}"}, {ItemRightBrace, "}"}, tEOF}},
+		{"unopened", "{}}", []lexed{{ItemLeftBrace, "{"}, {ItemRightBrace, "}"}, {ItemError, "unexpected '}'"}}},
+		{"unclosed", "{ 1", []lexed{{ItemLeftBrace, "{"}, {ItemSpace, " "}, {ItemNumber, "1"}, {ItemError, "unterminated procedure (procedure depth 1)"}}},
+	})
+}
+
+func TestParseDepth(t *testing.T) {
+	deep := strings.Repeat("[", 10*maxParseDepth)
+	for name, f := range map[string]func() error{
+		"ParseObject": func() error {
+			_, err := NewParser(NewLexer("test", deep)).ParseObject()
+			return err
+		},
+		"procedure": func() error {
+			_, err := NewParser(NewLexer("test", strings.Repeat("{", 10*maxParseDepth))).ParseObject()
+			return err
+		},
+		"FindLinearized": func() error {
+			_, _, err := FindLinearized("test", "1 0 obj "+deep)
+			return err
+		},
+		"Trailers": func() error {
+			_, err := Trailers("test", "trailer\n<< /A "+deep)
+			return err
+		},
+	} {
+		if err := f(); err == nil || !strings.Contains(err.Error(), "maximum depth") {
+			t.Errorf("%s: got %v, want a depth error", name, err)
+		}
+	}
+
+	// right at the limit is fine
+	in := strings.Repeat("[", maxParseDepth) + strings.Repeat("]", maxParseDepth)
+	if _, err := NewParser(NewLexer("test", in)).ParseObject(); err != nil {
+		t.Errorf("%d levels: %v", maxParseDepth, err)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	in := "<< /Type /Page /A 1 /Type /Catalog >>"
+
+	p := NewParser(NewLexer("test", in))
+	o, err := p.ParseObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := o.(Dict)["Type"]; got != Name("Catalog") {
+		t.Errorf("Type = %v, want the last value, Catalog", got)
+	}
+	want := []Diagnostic{{20, "duplicate dict key /Type, first seen at 3"}}
+	if !reflect.DeepEqual(p.Diagnostics, want) {
+		t.Errorf("Diagnostics = %v, want %v", p.Diagnostics, want)
+	}
+
+	p = NewParser(NewLexer("test", in))
+	p.Strict = true
+	if _, err := p.ParseObject(); err == nil || err.Error() != "test:20: duplicate dict key /Type, first seen at 3" {
+		t.Errorf("Strict got %v, want a duplicate key error", err)
+	}
+
+	// the same key in different dicts is fine
+	p = NewParser(NewLexer("test", "<< /A << /A 1 >> >>"))
+	if _, err := p.ParseObject(); err != nil || len(p.Diagnostics) > 0 {
+		t.Errorf("nested dicts: %v, %v", err, p.Diagnostics)
+	}
+}
+
+func TestObjectStream(t *testing.T) {
+	body := "11 0 12 10 << /A 1 >>[1 2 R] "
+	d := Dict{"Type": Name("ObjStm"), "N": int64(2), "First": int64(11)}
+	objs, err := ObjectStream("test", d, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IndirectObject{
+		{Ref{11, 0}, 11, Dict{"A": int64(1)}},
+		{Ref{12, 0}, 21, Array{Ref{1, 2}}},
+	}
+	if !reflect.DeepEqual(objs, want) {
+		t.Errorf("got %#v, want %#v", objs, want)
+	}
+
+	for _, test := range []struct {
+		name string
+		d    Dict
+	}{
+		{"not ObjStm", Dict{"Type": Name("XRef"), "N": int64(2), "First": int64(11)}},
+		{"First past body", Dict{"Type": Name("ObjStm"), "N": int64(2), "First": int64(len(body) + 1)}},
+		{"negative First", Dict{"Type": Name("ObjStm"), "N": int64(2), "First": int64(-1)}},
+		{"missing First", Dict{"Type": Name("ObjStm"), "N": int64(2)}},
+		{"First mid header", Dict{"Type": Name("ObjStm"), "N": int64(2), "First": int64(5)}},
+		{"huge N", Dict{"Type": Name("ObjStm"), "N": int64(1) << 60, "First": int64(11)}},
+		{"negative N", Dict{"Type": Name("ObjStm"), "N": int64(-1), "First": int64(11)}},
+	} {
+		if _, err := ObjectStream("test", test.d, body); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+	d = Dict{"Type": Name("ObjStm"), "N": int64(1), "First": int64(6)}
+	if _, err := ObjectStream("test", d, "11 99 << >>"); err == nil {
+		t.Error("offset past body: no error")
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	stream := func(length, body string) string {
+		return "1 0 obj\n<< " + length + " >>\nstream\n" + body + "endstream\nendobj"
+	}
+	bodyPos := Pos(len(stream("/Length 5", "")) - len("endstream\nendobj"))
+	tests := []struct {
+		name  string
+		input string
+		want  []Diagnostic
+	}{
+		{"exact", stream("/Length 5", "hello"), nil},
+		{"trailing LF", stream("/Length 5", "hello\n"), nil},
+		{"trailing CRLF", stream("/Length 5", "hello\r\n"), nil},
+		{"EOL counted", stream("/Length 6", "hello\n"), nil},
+		{"too short", stream("/Length 5", "hi\n"), []Diagnostic{{bodyPos, "stream /Length is 5 but the body is 3 bytes"}}},
+		{"too long", stream("/Length 5", "hello world\n"), []Diagnostic{{bodyPos, "stream /Length is 5 but the body is 12 bytes"}}},
+		{"indirect", stream("/Length 9 0 R", "hello\n"), []Diagnostic{{bodyPos + 4, "stream /Length is indirect (9 0 R), body is 6 bytes"}}},
+		{"missing", stream("/A 1", "hello\n"), []Diagnostic{{bodyPos - 5, "stream has no /Length, body is 6 bytes"}}},
+		{"not an integer", stream("/Length 5.0", "hello\n"), []Diagnostic{{bodyPos + 2, "stream /Length is not an integer"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewParser(NewLexer("test", test.input))
+			p.CheckLength = true
+			if _, err := p.ParseIndirect(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.Diagnostics, test.want) {
+				t.Errorf("Diagnostics = %v, want %v", p.Diagnostics, test.want)
+			}
+
+			// Strict makes a mismatch an error, but an indirect /Length
+			// is only ever noted
+			p = NewParser(NewLexer("test", test.input))
+			p.CheckLength, p.Strict = true, true
+			_, err := p.ParseIndirect()
+			if wantErr := test.want != nil && test.name != "indirect"; (err != nil) != wantErr {
+				t.Errorf("Strict: got %v, want error %v", err, wantErr)
+			}
+		})
+	}
+
+	// without CheckLength nothing is checked
+	p := NewParser(NewLexer("test", stream("/Length 5", "hi\n")))
+	if _, err := p.ParseIndirect(); err != nil || len(p.Diagnostics) > 0 {
+		t.Errorf("unchecked: %v, %v", err, p.Diagnostics)
+	}
+}
+
+func TestStartXRefs(t *testing.T) {
+	body := "%PDF-1.4\n1 0 obj\nnull\nendobj\nxref\n0 1\n0000000000 65535 f \n"
+	doc := body +
+		"startxref\n9\n%%EOF\n" + // the object
+		"startxref\n" + strconv.Itoa(strings.Index(body, "xref")) + "\n%%EOF\n" +
+		"startxref\n3\n%%EOF\n" + // inside the header
+		"startxref\n99999\n%%EOF\n" +
+		"startxref\n-1\n%%EOF\n"
+	at := func(i int) Pos { // position of the i'th startxref
+		pos := -1
+		for ; i >= 0; i-- {
+			pos += 1 + strings.Index(doc[pos+1:], "startxref")
+		}
+		return Pos(pos)
+	}
+	num := func(i int) Pos { return at(i) + Pos(len("startxref\n")) }
+
+	sxs, diags, err := StartXRefs("test", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StartXRef{
+		{at(0), 9},
+		{at(1), Pos(strings.Index(body, "xref"))},
+		{at(2), 3},
+		{at(3), 99999},
+		{at(4), -1},
+	}
+	if !reflect.DeepEqual(sxs, want) {
+		t.Errorf("got %v, want %v", sxs, want)
+	}
+	wantDiags := []Diagnostic{
+		{num(2), "startxref offset 3 is not at xref or an object"},
+		{num(3), "startxref offset 99999 out of range"},
+		{num(4), "startxref offset -1 out of range"},
+	}
+	if !reflect.DeepEqual(diags, wantDiags) {
+		t.Errorf("Diagnostics = %v, want %v", diags, wantDiags)
+	}
+
+	for _, bad := range []string{"startxref\n/A\n", "startxref\n1.5\n", "startxref"} {
+		if _, _, err := StartXRefs("test", bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestCheckObjects(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Diagnostic
+	}{
+		{"clean", "1 0 obj\nnull\nendobj\n2 0 obj\n<< >>\nendobj\n", nil},
+		{"missing endobj", "1 0 obj\nnull\n2 0 obj\nnull\nendobj\n", []Diagnostic{
+			{0, "object 1 0 not closed before object 2 0 at 13"},
+		}},
+		{"missing endobj at EOF", "1 0 obj\nnull\nendobj\n2 5 obj\nnull\n", []Diagnostic{
+			{20, "object 2 5 not closed before end of input"},
+		}},
+		{"stray endobj", "1 0 obj\nnull\nendobj\nendobj\n", []Diagnostic{{20, "endobj outside an object"}}},
+		{"obj without number", "/A 0 obj\nnull\nendobj\n", []Diagnostic{
+			{5, "obj without an object number"}, {14, "endobj outside an object"},
+		}},
+		{"swallowed object", "1 0 obj\n<< /Length 3 >>\nstream\nabc\n" +
+			"2 0 obj\nnull\nendobj\n3 0 obj\n<< >>\nstream\nxyz\nendstream\nendobj\n",
+			[]Diagnostic{{35, "stream body runs into an object header, endstream may be missing"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := CheckObjects("test", test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	if _, err := CheckObjects("test", "1 0 obj\n@"); err == nil {
+		t.Error("no error for input that doesn't lex")
+	}
+}
+
+func TestFindLinearized(t *testing.T) {
+	doc := func(l int) string {
+		return "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n" +
+			"43 0 obj\n<< /Linearized 1 /L " + strconv.Itoa(l) + " /H [ 500 120 ] /O 45 /E 900 /N 2 /T 1000 >>\nendobj\n" +
+			"44 0 obj\nnull\nendobj\n"
+	}
+	// find the length that makes /L match, allowing for its own digits
+	n := len(doc(0))
+	for len(doc(n)) != n {
+		n = len(doc(n))
+	}
+
+	lin, diags, err := FindLinearized("test", doc(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) > 0 {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+	want := Linearized{L: int64(n), H: Array{int64(500), int64(120)}, O: 45, E: 900, N: 2, T: 1000}
+	if lin == nil || lin.Obj == nil || lin.Obj.Num != 43 {
+		t.Fatalf("got %+v, want object 43", lin)
+	}
+	want.Obj = lin.Obj
+	if !reflect.DeepEqual(*lin, want) {
+		t.Errorf("got %+v, want %+v", *lin, want)
+	}
+
+	// a file updated after linearization no longer matches /L
+	lin, diags, err = FindLinearized("test", doc(n)+"% appended\n")
+	if err != nil || lin == nil {
+		t.Fatalf("%v, %v", lin, err)
+	}
+	wantDiags := []Diagnostic{{lin.Obj.Pos, fmt.Sprintf("linearized /L %d doesn't match file length %d", n, n+len("% appended\n"))}}
+	if !reflect.DeepEqual(diags, wantDiags) {
+		t.Errorf("Diagnostics = %v, want %v", diags, wantDiags)
+	}
+
+	for _, plain := range []string{
+		"%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n",
+		"%PDF-1.4\n1 0 obj\n[ /Linearized ]\nendobj\n",
+	} {
+		lin, diags, err := FindLinearized("test", plain)
+		if lin != nil || diags != nil || err != nil {
+			t.Errorf("not linearized: got %+v, %v, %v", lin, diags, err)
+		}
+	}
+	if _, _, err := FindLinearized("test", "%PDF-1.4\n"); err == nil {
+		t.Error("no error without any object")
+	}
+}
+
+func TestObjectBytes(t *testing.T) {
+	def1 := "2 0 obj\n<< /V 1 >>\nendobj"
+	def2 := "2 0 obj\n<< /V 2 >>\nendobj"
+	strm := "3 0 obj\n<< /Length 12 >>\nstream\nfake endobj\nendstream\nendobj"
+	doc := "%PDF-1.4\n1 0 obj\nnull\nendobj\n" + def1 + "\n" + strm + "\n" +
+		"2 1 obj\nnull\nendobj\n" + def2 + "\n%%EOF\n"
+
+	if got := ObjectDefinitions(doc, 2, 0); !reflect.DeepEqual(got, []string{def1, def2}) {
+		t.Errorf("ObjectDefinitions = %q, want both, in order", got)
+	}
+	if got, ok := ObjectBytes(doc, 2, 0); !ok || got != def2 {
+		t.Errorf("ObjectBytes = %q, %v, want the last definition", got, ok)
+	}
+	if got, ok := ObjectBytes(doc, 3, 0); !ok || got != strm {
+		t.Errorf("stream object = %q, %v, want it whole", got, ok)
+	}
+	if got, ok := ObjectBytes(doc, 2, 1); !ok || got != "2 1 obj\nnull\nendobj" {
+		t.Errorf("generation 1 = %q, %v", got, ok)
+	}
+	for _, r := range []Ref{{4, 0}, {1, 1}} {
+		if got, ok := ObjectBytes(doc, r.Num, r.Gen); ok || got != "" {
+			t.Errorf("missing object %v = %q, %v", r, got, ok)
+		}
+	}
+
+	// a definition without endobj has no known extent
+	if got := ObjectDefinitions("1 0 obj\nnull\n2 0 obj\nnull\nendobj\n", 1, 0); got != nil {
+		t.Errorf("unterminated definition = %q, want none", got)
+	}
+}
@@ -0,0 +1,180 @@
+// (c) Ben Nagy 2015
+
+package pdflex
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Decode returns the bytes represented by a literal or hex string item, with
+// escapes, line continuations and hex digits decoded. Val keeps the raw
+// form for callers that need it. Items from WithLazyValues have no Val to
+// decode, so they are an error.
+func (i Item) Decode() (string, error) {
+	if i.lazy() {
+		return "", fmt.Errorf("can't decode lazy %v item at %d", i.Typ, i.Pos)
+	}
+	switch i.Typ {
+	case ItemString:
+		return decodeLiteral(i.Val), nil
+	case ItemHexString:
+		return decodeHex(i.Val)
+	}
+	return "", fmt.Errorf("can't decode %v item", i.Typ)
+}
+
+// Text decodes a string item as a PDF text string, returning UTF-8. Text
+// strings that start with the byte order mark 0xFE 0xFF are UTF-16BE, anything
+// else is PDFDocEncoding. A trailing odd byte in UTF-16 becomes U+FFFD.
+// cf PDF3200_2008.pdf 7.9.2.2
+func (i Item) Text() (string, error) {
+	s, err := i.Decode()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(s, "\xfe\xff") {
+		return decodeUTF16BE(s[2:]), nil
+	}
+	return decodePDFDoc(s), nil
+}
+
+// decodeLiteral decodes the contents of a literal string, including the
+// parens, which the lexer has already checked are balanced.
+// cf PDF3200_2008.pdf 7.3.4.2
+func decodeLiteral(s string) string {
+	s = s[1 : len(s)-1]
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\r':
+			// an unescaped EOL of any kind is read as a single LF
+			if i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+			b = append(b, '\n')
+		case c != '\\':
+			b = append(b, c)
+		case i+1 == len(s):
+			// lone trailing backslash, ignored
+		default:
+			i++
+			switch c = s[i]; c {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'b':
+				b = append(b, '\b')
+			case 'f':
+				b = append(b, '\f')
+			case '\r':
+				// line continuation, the EOL is dropped
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				// up to three octal digits, high-order overflow is ignored
+				n := c - '0'
+				for j := 0; j < 2 && i+1 < len(s) && '0' <= s[i+1] && s[i+1] <= '7'; j++ {
+					i++
+					n = n<<3 | (s[i] - '0')
+				}
+				b = append(b, n)
+			default:
+				// \( \) \\ and, against the spec, any other character stand
+				// for themselves
+				b = append(b, c)
+			}
+		}
+	}
+	return string(b)
+}
+
+// decodeHex decodes a hex string, including the angle brackets. Whitespace is
+// ignored and a missing final digit is taken to be 0.
+// cf PDF3200_2008.pdf 7.3.4.3
+func decodeHex(s string) (string, error) {
+	s = s[1 : len(s)-1]
+	b := make([]byte, 0, len(s)/2+1)
+	var (
+		c   byte
+		odd bool
+	)
+	for i := 0; i < len(s); i++ {
+		var d byte
+		switch x := s[i]; {
+		case '0' <= x && x <= '9':
+			d = x - '0'
+		case 'a' <= x && x <= 'f':
+			d = x - 'a' + 10
+		case 'A' <= x && x <= 'F':
+			d = x - 'A' + 10
+		case isPDFWhitespace(rune(x)):
+			continue
+		default:
+			return "", fmt.Errorf("illegal character in hexstring: %q", x)
+		}
+		if odd {
+			b = append(b, c|d)
+		} else {
+			c = d << 4
+		}
+		odd = !odd
+	}
+	if odd {
+		b = append(b, c)
+	}
+	return string(b), nil
+}
+
+// decodeUTF16BE decodes UTF-16BE text without a byte order mark.
+func decodeUTF16BE(s string) string {
+	u := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		u = append(u, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	out := string(utf16.Decode(u))
+	if len(s)%2 != 0 {
+		out += string(utf8.RuneError)
+	}
+	return out
+}
+
+// pdfDocRunes holds the PDFDocEncoding code points that differ from ISO
+// Latin-1. Every other byte has the same value as its code point. 0x7F, 0x9F
+// and 0xAD are undefined, and decode as U+FFFD.
+// cf PDF3200_2008.pdf Annex D.2
+var pdfDocRunes = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1a: 'ˆ', 0x1b: '˙',
+	0x1c: '˝', 0x1d: '˛', 0x1e: '˚', 0x1f: '˜',
+	0x7f: utf8.RuneError,
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…',
+	0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8a: '−', 0x8b: '‰',
+	0x8c: '„', 0x8d: '“', 0x8e: '”', 0x8f: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ',
+	0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9a: 'ı', 0x9b: 'ł',
+	0x9c: 'œ', 0x9d: 'š', 0x9e: 'ž', 0x9f: utf8.RuneError,
+	0xa0: '€', 0xad: utf8.RuneError,
+}
+
+// decodePDFDoc decodes PDFDocEncoded text.
+func decodePDFDoc(s string) string {
+	r := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		if x, ok := pdfDocRunes[s[i]]; ok {
+			r[i] = x
+		} else {
+			r[i] = rune(s[i])
+		}
+	}
+	return string(r)
+}
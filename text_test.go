@@ -0,0 +1,88 @@
+// (c) Ben Nagy 2015
+
+package pdflex
+
+import "testing"
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		typ  ItemType
+		val  string
+		want string
+	}{
+		{ItemString, `(abc)`, "abc"},
+		{ItemString, `(a\(b\)c)`, "a(b)c"},
+		{ItemString, `(\n\r\t\b\f\\)`, "\n\r\t\b\f\\"},
+		{ItemString, `(\101\102)`, "AB"},
+		{ItemString, `(\0053)`, "\x053"},
+		{ItemString, `(\1)`, "\x01"},
+		{ItemString, `(\12x)`, "\nx"},
+		{ItemString, `(\777)`, "\xff"}, // high-order overflow is ignored
+		{ItemString, `(\400)`, "\x00"},
+		{ItemString, `(\8)`, "8"},
+		{ItemString, `(\q)`, "q"},
+		{ItemString, "(a\r\nb\rc)", "a\nb\nc"},
+		{ItemString, "(a\\\r\nb\\\nc\\\rd)", "abcd"},
+		{ItemString, `()`, ""},
+		{ItemHexString, `<4142>`, "AB"},
+		{ItemHexString, `<41 4 2>`, "AB"},
+		{ItemHexString, "<6a6B\n>", "jk"},
+		{ItemHexString, `<414>`, "A@"},
+		{ItemHexString, `<7>`, "p"},
+		{ItemHexString, `<>`, ""},
+	}
+	for _, test := range tests {
+		got, err := Item{Typ: test.typ, Val: test.val}.Decode()
+		if err != nil || got != test.want {
+			t.Errorf("%s: Decode = %q, %v, want %q", test.val, got, err, test.want)
+		}
+	}
+
+	for _, it := range []Item{
+		{Typ: ItemHexString, Val: "<4G>"},
+		{Typ: ItemName, Val: "/A"},
+		{Typ: ItemString, Pos: 3, end: 8}, // lazy
+	} {
+		if got, err := it.Decode(); err == nil {
+			t.Errorf("%v %q: Decode = %q, want an error", it.Typ, it.Val, got)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		// UTF-16BE after a byte order mark
+		{`<FEFF00410042>`, "AB"},
+		{`(\376\377\000A\000B)`, "AB"},
+		{`<FEFFD83DDE00>`, "😀"},
+		{`<FEFF004100>`, "A�"},
+		{`<FEFF>`, ""},
+		{`<FEFFD83D>`, "�"}, // unpaired surrogate
+
+		// PDFDocEncoding
+		{`(plain)`, "plain"},
+		{`<18191A1B1C1D1E1F>`, "˘ˇˆ˙˝˛˚˜"},
+		{`<808182838485868788898A8B8C8D8E8F>`, "•†‡…—–ƒ⁄‹›−‰„“”‘"},
+		{`<909192939495969798999A9B9C9D9E>`, "’‚™ﬁﬂŁŒŠŸŽıłœšž"},
+		{`<A0>`, "€"},
+		{`<7F9FAD>`, "���"},
+		{`<E9A9FF>`, "é©ÿ"},
+		{`<FE>`, "þ"}, // half a byte order mark is just text
+	}
+	for _, test := range tests {
+		typ := ItemString
+		if test.val[0] == '<' {
+			typ = ItemHexString
+		}
+		got, err := Item{Typ: typ, Val: test.val}.Text()
+		if err != nil || got != test.want {
+			t.Errorf("%s: Text = %q, %v, want %q", test.val, got, err, test.want)
+		}
+	}
+	if _, err := (Item{Typ: ItemNumber, Val: "1"}).Text(); err == nil {
+		t.Error("Text of a number succeeded")
+	}
+}